@@ -6,11 +6,13 @@ import (
 )
 
 // Artifact is the result of running the Bhyve builder, namely a set
-// of files associated with the resulting machine.
+// of files associated with the resulting machine, and any datasets it was
+// published to.
 type Artifact struct {
-	dir   string
-	f     []string
-	state map[string]interface{}
+	dir      string
+	f        []string
+	datasets []string
+	state    map[string]interface{}
 }
 
 func (*Artifact) BuilderId() string {
@@ -34,5 +36,11 @@ func (a *Artifact) State(name string) interface{} {
 }
 
 func (a *Artifact) Destroy() error {
+	for _, dataset := range a.datasets {
+		if err := destroyDataset(dataset); err != nil {
+			return err
+		}
+	}
+
 	return os.RemoveAll(a.dir)
 }
@@ -0,0 +1,197 @@
+package bhyve
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type bhyveArgsTemplateData struct {
+	Name     string
+	DiskPath string
+	VNCPort  int
+	ISOPath  string
+}
+
+// parsePCISlot returns the bus and slot from the value of a -s argument,
+// which is one of slot[:function], or bus:slot:function, followed by the
+// device emulation.
+func parsePCISlot(value string) (int, int, error) {
+	fields := strings.Split(strings.SplitN(value, ",", 2)[0], ":")
+
+	var bus, slot string
+	switch len(fields) {
+	case 1, 2:
+		bus, slot = "0", fields[0]
+	case 3:
+		bus, slot = fields[0], fields[1]
+	default:
+		return 0, 0, fmt.Errorf("invalid PCI slot %q", value)
+	}
+
+	b, err := strconv.Atoi(bus)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid PCI bus in %q", value)
+	}
+
+	s, err := strconv.Atoi(slot)
+	if err != nil || s < 0 || s > SlotMax {
+		return 0, 0, fmt.Errorf("invalid PCI slot in %q", value)
+	}
+
+	return b, s, nil
+}
+
+// bhyveArgsSlots returns the slots on bus 0 that are claimed by -s entries
+// in bhyve_args.  Entries which have not been interpolated yet are skipped.
+func (c *Config) bhyveArgsSlots() map[int]bool {
+	slots := map[int]bool{}
+
+	for _, arg := range c.BhyveArgs {
+		if len(arg) != 2 || arg[0] != "-s" || strings.Contains(arg[1], "{{") {
+			continue
+		}
+		if bus, slot, err := parsePCISlot(arg[1]); err == nil && bus == 0 {
+			slots[slot] = true
+		}
+	}
+
+	return slots
+}
+
+// validateBhyveArgs checks that each bhyve_args entry is a flag with at most
+// one value, and that -s entries do not clash with the built-in devices this
+// build uses or each other.
+func (c *Config) validateBhyveArgs() []error {
+	var errs []error
+
+	builtin := c.usedPCISlots()
+	seen := map[int]bool{}
+
+	for i, arg := range c.BhyveArgs {
+		if len(arg) == 0 || len(arg) > 2 || !strings.HasPrefix(arg[0], "-") {
+			errs = append(errs, fmt.Errorf(
+				"bhyve_args[%d] must be a flag followed by an optional value", i))
+			continue
+		}
+
+		if arg[0] != "-s" {
+			continue
+		}
+		if len(arg) != 2 {
+			errs = append(errs, fmt.Errorf("bhyve_args[%d]: -s requires a value", i))
+			continue
+		}
+		if strings.Contains(arg[1], "{{") {
+			continue
+		}
+
+		bus, slot, err := parsePCISlot(arg[1])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("bhyve_args[%d]: %s", i, err))
+			continue
+		}
+		if bus != 0 {
+			continue
+		}
+
+		if name, ok := builtin[slot]; ok {
+			errs = append(errs, fmt.Errorf(
+				"bhyve_args[%d]: slot %d conflicts with the built-in %s device", i, slot, name))
+		}
+		if seen[slot] {
+			errs = append(errs, fmt.Errorf(
+				"bhyve_args[%d]: slot %d is used more than once", i, slot))
+		}
+		seen[slot] = true
+	}
+
+	return errs
+}
+
+// The bhyve flags that take no value, and the configuration setting that
+// each one is equivalent to.
+var bhyveFlagSettings = map[string]vmSetting{
+	"-A": {"acpi_tables", "true"},
+	"-D": {"destroy_on_poweroff", "true"},
+	"-e": {"x86.strictio", "true"},
+	"-H": {"x86.vmexit_on_hlt", "true"},
+	"-P": {"x86.vmexit_on_pause", "true"},
+	"-S": {"memory.wired", "true"},
+	"-u": {"rtc.use_localtime", "false"},
+	"-W": {"virtio_msix", "false"},
+	"-w": {"x86.strictmsr", "false"},
+	"-x": {"x86.x2apic", "true"},
+	"-Y": {"x86.mptable", "false"},
+}
+
+// applyBhyveArgs applies the interpolated bhyve_args to vm, so that they
+// replace the generated settings they overlap with and are recorded in the
+// configuration file.  -c replaces the whole CPU topology, -l replaces the
+// bootrom or serial port it names, and -m, -o, -U and the flags above set
+// the equivalent keys.  -s devices, and anything that has no equivalent
+// setting, are returned to be passed on the command line after -k.
+func applyBhyveArgs(vm *vmConfig, args [][]string) []string {
+	extra := []string{}
+
+	for _, arg := range args {
+		if len(arg) == 1 {
+			if setting, ok := bhyveFlagSettings[arg[0]]; ok {
+				vm.set(setting.key, setting.value)
+				continue
+			}
+			extra = append(extra, arg...)
+			continue
+		}
+
+		switch flag, value := arg[0], arg[1]; flag {
+		case "-c":
+			for _, key := range []string{"cpus", "sockets", "cores", "threads", "maxcpus"} {
+				vm.unset(key)
+			}
+			if !strings.Contains(value, "=") {
+				vm.set("cpus", value)
+				continue
+			}
+			for _, option := range strings.Split(value, ",") {
+				kv := strings.SplitN(option, "=", 2)
+				if len(kv) == 2 {
+					vm.set(kv[0], kv[1])
+				} else {
+					vm.set("cpus", kv[0])
+				}
+			}
+		case "-l":
+			fields := strings.Split(value, ",")
+			switch device := fields[0]; {
+			case device == "bootrom" && len(fields) > 1:
+				vm.unset("lpc.bootrom")
+				vm.unset("lpc.bootvars")
+				vm.set("lpc.bootrom", fields[1])
+				if len(fields) > 2 {
+					vm.set("lpc.bootvars", fields[2])
+				}
+			case strings.HasPrefix(device, "com") && len(fields) > 1:
+				vm.unset(fmt.Sprintf("lpc.%s", device))
+				vm.set(fmt.Sprintf("lpc.%s.path", device), strings.Join(fields[1:], ","))
+			default:
+				extra = append(extra, arg...)
+			}
+		case "-m":
+			vm.set("memory.size", value)
+		case "-o":
+			kv := strings.SplitN(value, "=", 2)
+			if len(kv) != 2 {
+				extra = append(extra, arg...)
+				continue
+			}
+			vm.set(kv[0], kv[1])
+		case "-U":
+			vm.set("uuid", value)
+		default:
+			extra = append(extra, arg...)
+		}
+	}
+
+	return extra
+}
@@ -0,0 +1,136 @@
+package bhyve
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePCISlot(t *testing.T) {
+	tests := []struct {
+		value string
+		bus   int
+		slot  int
+		err   bool
+	}{
+		{"10,virtio-rnd", 0, 10, false},
+		{"10:1,virtio-rnd", 0, 10, false},
+		{"1:10:0,virtio-rnd", 1, 10, false},
+		{"31", 0, 31, false},
+		{"32,virtio-rnd", 0, 0, true},
+		{"-1,virtio-rnd", 0, 0, true},
+		{"x,virtio-rnd", 0, 0, true},
+		{"x:10:0,virtio-rnd", 0, 0, true},
+		{"0:1:2:3,virtio-rnd", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		bus, slot, err := parsePCISlot(tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("parsePCISlot(%q) error = %v, want error %v", tt.value, err, tt.err)
+			continue
+		}
+		if bus != tt.bus || slot != tt.slot {
+			t.Errorf("parsePCISlot(%q) = %d, %d, want %d, %d",
+				tt.value, bus, slot, tt.bus, tt.slot)
+		}
+	}
+}
+
+func TestValidateBhyveArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args [][]string
+		errs int
+	}{
+		{"empty", nil, 0},
+		{"device", [][]string{{"-s", "10,virtio-rnd"}}, 0},
+		{"flags", [][]string{{"-H"}, {"-c", "cpus=4"}}, 0},
+		{"other bus", [][]string{{"-s", "1:4:0,virtio-rnd"}}, 0},
+		{"template", [][]string{{"-s", "{{ .Name }},virtio-rnd"}}, 0},
+		{"boot disk slot", [][]string{{"-s", "4,virtio-rnd"}}, 1},
+		{"lpc slot", [][]string{{"-s", "31,lpc"}}, 1},
+		{"duplicate", [][]string{{"-s", "10,virtio-rnd"}, {"-s", "10:1,virtio-rnd"}}, 1},
+		{"bad slot", [][]string{{"-s", "x,virtio-rnd"}}, 1},
+		{"missing value", [][]string{{"-s"}}, 1},
+		{"not a flag", [][]string{{"s", "10,virtio-rnd"}}, 1},
+		{"too many values", [][]string{{"-s", "10", "virtio-rnd"}}, 1},
+		{"empty entry", [][]string{{}}, 1},
+	}
+
+	for _, tt := range tests {
+		c := &Config{BhyveArgs: tt.args}
+		if errs := c.validateBhyveArgs(); len(errs) != tt.errs {
+			t.Errorf("%s: errors = %v, want %d", tt.name, errs, tt.errs)
+		}
+	}
+}
+
+func TestValidateBhyveArgsUnusedSlots(t *testing.T) {
+	args := [][]string{{"-s", "3,virtio-rnd"}, {"-s", "7,virtio-9p,share=/tmp"}, {"-s", "30,fbuf"}}
+
+	tests := []struct {
+		name   string
+		config func(c *Config)
+		errs   int
+	}{
+		{"all attached", func(c *Config) { c.CDFiles = []string{"seed"} }, 3},
+		{"defaults", func(c *Config) {}, 2},
+		{"disk_image", func(c *Config) { c.DiskImage = true }, 1},
+		{"headless disk_image", func(c *Config) {
+			c.DiskImage = true
+			c.VNCConfig.DisableVNC = true
+		}, 0},
+	}
+
+	for _, tt := range tests {
+		c := &Config{BhyveArgs: args}
+		tt.config(c)
+		if errs := c.validateBhyveArgs(); len(errs) != tt.errs {
+			t.Errorf("%s: errors = %v, want %d", tt.name, errs, tt.errs)
+		}
+	}
+}
+
+func TestApplyBhyveArgs(t *testing.T) {
+	vm := newVMConfig("test")
+	vm.set("cpus", "2")
+	vm.set("sockets", "1")
+	vm.set("cores", "2")
+	vm.set("memory.size", "1024")
+	vm.set("lpc.bootrom", "/usr/share/bhyve/firmware/BHYVE.fd")
+	vm.set("lpc.bootvars", "/tmp/efivars.fd")
+	vm.set("lpc.com1.path", "/tmp/com1")
+	vm.set("rtc.use_localtime", "true")
+
+	extra := applyBhyveArgs(vm, [][]string{
+		{"-c", "cpus=4,threads=2"},
+		{"-m", "4G"},
+		{"-l", "bootrom,/tmp/CSM.fd"},
+		{"-l", "com1,stdio"},
+		{"-o", "x86.verbosemsr=true"},
+		{"-u"},
+		{"-U", "3b5e3c4e-0d6f-4f86-9d2a-1f3f1d7f0a6b"},
+		{"-s", "10,virtio-rnd"},
+		{"-K", "us"},
+		{"-o", "nokey"},
+	})
+
+	want := `name=test
+memory.size=4G
+rtc.use_localtime=false
+cpus=4
+threads=2
+lpc.bootrom=/tmp/CSM.fd
+lpc.com1.path=stdio
+x86.verbosemsr=true
+uuid=3b5e3c4e-0d6f-4f86-9d2a-1f3f1d7f0a6b
+`
+	if got := vm.render(); got != want {
+		t.Errorf("render() =\n%s\nwant\n%s", got, want)
+	}
+
+	want_extra := []string{"-s", "10,virtio-rnd", "-K", "us", "-o", "nokey"}
+	if !reflect.DeepEqual(extra, want_extra) {
+		t.Errorf("extra = %q, want %q", extra, want_extra)
+	}
+}
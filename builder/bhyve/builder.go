@@ -59,15 +59,31 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 			Url:         b.config.ISOUrls,
 		},
 		new(stepPrepareOutputDir),
-		&commonsteps.StepCreateCD{
+	)
+
+	// With cloud-init the cd_files are added to the seed CD-ROM instead,
+	// which is created once the HTTP server address is known.
+	if !b.config.useCloudInit() {
+		steps = append(steps, &commonsteps.StepCreateCD{
 			Files:   b.config.CDConfig.CDFiles,
 			Content: b.config.CDConfig.CDContent,
 			Label:   b.config.CDConfig.CDLabel,
-		},
+		})
+	}
+
+	steps = append(steps,
 		new(stepHTTPIPDiscover),
 		commonsteps.HTTPServerFromHTTPConfig(&b.config.HTTPConfig),
 	)
 
+	if b.config.useCloudInit() {
+		steps = append(steps, new(stepCreateCloudInit))
+	}
+
+	if b.config.EFIVars != "" {
+		steps = append(steps, new(stepCreateEFIVars))
+	}
+
 	if b.config.DiskUseZVOL {
 		steps = append(steps, new(stepCreateZvol))
 	} else {
@@ -78,8 +94,15 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 		steps = append(steps, new(stepCreateVNIC))
 	}
 
+	if b.config.useSerialConsole() {
+		steps = append(steps, new(stepConfigureSerial))
+	}
+
+	if !b.config.VNCConfig.DisableVNC {
+		steps = append(steps, new(stepConfigureVNC))
+	}
+
 	steps = append(steps,
-		new(stepConfigureVNC),
 		&stepBhyve{
 			name: b.config.VMName,
 		},
@@ -104,6 +127,16 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 
 	if b.config.DiskUseZVOL {
 		steps = append(steps, &stepCreateSnapshot{})
+	} else {
+		steps = append(steps, &stepExportDisk{})
+	}
+
+	if b.config.writesTritonManifest() {
+		steps = append(steps, &stepCreateTritonManifest{})
+	}
+
+	if b.config.PublishDataset != "" {
+		steps = append(steps, &stepPublishDataset{})
 	}
 
 	// Run!
@@ -138,15 +171,25 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 		return nil, err
 	}
 
+	datasets, _ := state.Get("published_datasets").([]string)
+
 	artifact := &Artifact{
-		dir:   b.config.OutputDir,
-		f:     files,
-		state: make(map[string]interface{}),
+		dir:      b.config.OutputDir,
+		f:        files,
+		datasets: datasets,
+		state:    make(map[string]interface{}),
 	}
 
 	artifact.state["generated_data"] = state.Get("generated_data")
 	artifact.state["diskName"] = b.config.VMName
 	artifact.state["diskSize"] = b.config.DiskSize
+	artifact.state["vm_uuid"] = state.Get("vm_uuid")
+	artifact.state["output_files"] = state.Get("output_files")
+	artifact.state["diskType"] = b.config.OutputFormat[0]
+	artifact.state["zfs_base_snapshot"] = state.Get("zfs_base_snapshot")
+	artifact.state["zfs_stream_type"] = state.Get("zfs_stream_type")
+	artifact.state["triton_image_uuid"] = state.Get("triton_image_uuid")
+	artifact.state["published_datasets"] = datasets
 
 	return artifact, nil
 }
@@ -1,12 +1,18 @@
-//go:generate packer-sdc mapstructure-to-hcl2 -type Config
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config,NICConfig,TritonImageConfig,TritonNetworkConfig,TritonRequirementsConfig
 
 package bhyve
 
 import (
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/google/uuid"
 	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
 	"github.com/hashicorp/packer-plugin-sdk/common"
 	"github.com/hashicorp/packer-plugin-sdk/multistep/commonsteps"
@@ -17,47 +23,96 @@ import (
 )
 
 type CPUConfig struct {
-	CpuCount    int `mapstructure:"cpus" required:"false"`
-	SocketCount int `mapstructure:"sockets" required:"false"`
-	CoreCount   int `mapstructure:"cores" required:"false"`
-	ThreadCount int `mapstructure:"threads" required:"false"`
+	CpuCount    int            `mapstructure:"cpus" required:"false"`
+	SocketCount int            `mapstructure:"sockets" required:"false"`
+	CoreCount   int            `mapstructure:"cores" required:"false"`
+	ThreadCount int            `mapstructure:"threads" required:"false"`
+	CPUPinning  map[string]int `mapstructure:"cpu_pinning" required:"false"`
 }
 
-func (c CPUConfig) cmdline() string {
+// configure sets the vCPU count, topology and pinning of the guest.
+func (c CPUConfig) configure(vm *vmConfig) {
 	totalVCpus := c.getMaxCPUs()
-	if totalVCpus == 0 && c.CpuCount == 0 {
-		return "1"
-	}
-
-	cpuCount := c.CpuCount
-
-	if cpuCount == 0 {
+	if c.CpuCount == 0 && totalVCpus != 0 {
 		log.Printf("CPU count at default value, setting to topology maximum: %d", totalVCpus)
-		cpuCount = totalVCpus
 	}
 
-	if totalVCpus > cpuCount {
+	if totalVCpus > c.CpuCount && c.CpuCount != 0 {
 		log.Print("CPU count lower than what's described in topology." +
 			"This will negatively impact performance.")
 	}
 
-	if cpuCount > totalVCpus && totalVCpus != 0 {
+	if c.CpuCount > totalVCpus && totalVCpus != 0 {
 		log.Printf("CPU count is greater than what topology allows, setting to max CPU count of the provided topology: %d", totalVCpus)
-		cpuCount = totalVCpus
 	}
 
-	cpustr := fmt.Sprintf("cpus=%d", cpuCount)
+	vm.set("cpus", strconv.Itoa(c.vcpus()))
 	if c.SocketCount > 0 {
-		cpustr = fmt.Sprintf("%s,sockets=%d", cpustr, c.SocketCount)
+		vm.set("sockets", strconv.Itoa(c.SocketCount))
 	}
 	if c.CoreCount > 0 {
-		cpustr = fmt.Sprintf("%s,cores=%d", cpustr, c.CoreCount)
+		vm.set("cores", strconv.Itoa(c.CoreCount))
 	}
 	if c.ThreadCount > 0 {
-		cpustr = fmt.Sprintf("%s,threads=%d", cpustr, c.ThreadCount)
+		vm.set("threads", strconv.Itoa(c.ThreadCount))
+	}
+
+	// Order the pinning by vCPU so that the file is reproducible.
+	vcpus := []int{}
+	for vcpu := range c.CPUPinning {
+		v, _ := strconv.Atoi(vcpu)
+		vcpus = append(vcpus, v)
+	}
+	sort.Ints(vcpus)
+
+	for _, vcpu := range vcpus {
+		vm.set(fmt.Sprintf("vcpu.%d.cpuset", vcpu),
+			strconv.Itoa(c.CPUPinning[strconv.Itoa(vcpu)]))
+	}
+}
+
+// vcpus returns the number of vCPUs the guest ends up with, taking the
+// topology into account in the same way as configure().
+func (c CPUConfig) vcpus() int {
+	totalVCpus := c.getMaxCPUs()
+	if totalVCpus == 0 && c.CpuCount == 0 {
+		return 1
+	}
+
+	cpuCount := c.CpuCount
+
+	if cpuCount == 0 {
+		cpuCount = totalVCpus
+	}
+
+	if cpuCount > totalVCpus && totalVCpus != 0 {
+		cpuCount = totalVCpus
+	}
+
+	return cpuCount
+}
+
+func (c CPUConfig) validatePinning() []error {
+	var errs []error
+
+	vcpus := c.vcpus()
+	for vcpu, hostcpu := range c.CPUPinning {
+		v, err := strconv.Atoi(vcpu)
+		if err != nil || strconv.Itoa(v) != vcpu {
+			errs = append(errs, fmt.Errorf("cpu_pinning: invalid vCPU %q", vcpu))
+			continue
+		}
+		if v < 0 || v >= vcpus {
+			errs = append(errs, fmt.Errorf(
+				"cpu_pinning: vCPU %d is out of range, the guest has %d vCPUs", v, vcpus))
+		}
+		if hostcpu < 0 {
+			errs = append(errs, fmt.Errorf(
+				"cpu_pinning: invalid host CPU %d for vCPU %d", hostcpu, v))
+		}
 	}
 
-	return cpustr
+	return errs
 }
 
 func (c CPUConfig) getMaxCPUs() int {
@@ -84,6 +139,11 @@ func (c CPUConfig) getMaxCPUs() int {
 	return totalVCPUs
 }
 
+// The namespace for the default vm_uuid, which is a name based UUID of
+// vm_name.
+var vmUUIDNamespace = uuid.NewSHA1(uuid.NameSpaceURL,
+	[]byte("https://github.com/tritondatacenter/packer-plugin-bhyve"))
+
 type Config struct {
 	common.PackerConfig            `mapstructure:",squash"`
 	commonsteps.HTTPConfig         `mapstructure:",squash"`
@@ -92,24 +152,58 @@ type Config struct {
 	bootcommand.VNCConfig          `mapstructure:",squash"`
 	shutdowncommand.ShutdownConfig `mapstructure:",squash"`
 	CPUConfig                      `mapstructure:",squash"`
-
-	BootSteps      [][]string `mapstructure:"boot_steps" required:"false"`
-	CommConfig     CommConfig `mapstructure:",squash"`
-	DiskName       string     `mapstructure:"disk_name" required:"false"`
-	DiskSize       string     `mapstructure:"disk_size" required:"false"`
-	DiskUseZVOL    bool       `mapstructure:"disk_use_zvol" required:"false"`
-	DiskZPool      string     `mapstructure:"disk_zpool" required:"false"`
-	HostNIC        string     `mapstructure:"host_nic"`
-	MemorySize     int        `mapstructure:"memory" required:"false"`
-	OutputDir      string     `mapstructure:"output_directory" required:"false"`
-	VMName         string     `mapstructure:"vm_name" required:"false"`
-	VNCBindAddress string     `mapstructure:"vnc_bind_address" required:"false"`
-	VNCPortMax     int        `mapstructure:"vnc_port_max"`
-	VNCPortMin     int        `mapstructure:"vnc_port_min" required:"false"`
-	VNCUsePassword bool       `mapstructure:"vnc_use_password" required:"false"`
-	VNICCreate     bool       `mapstructure:"vnic_create" required:"false"`
-	VNICName       string     `mapstructure:"vnic_name" required:"false"`
-	VNICLink       string     `mapstructure:"vnic_link" required:"false"`
+	GuestConfig                    `mapstructure:",squash"`
+	ZFSSendConfig                  `mapstructure:",squash"`
+	ZvolConfig                     `mapstructure:",squash"`
+
+	AdditionalDiskSize     []string          `mapstructure:"disk_additional_size" required:"false"`
+	AdditionalCache        string            `mapstructure:"disk_additional_cache" required:"false"`
+	AdditionalReadOnly     bool              `mapstructure:"disk_additional_read_only" required:"false"`
+	AdditionalSectorSize   string            `mapstructure:"disk_additional_sector_size" required:"false"`
+	AdditionalNICs         []NICConfig       `mapstructure:"additional_nics" required:"false"`
+	BhyveArgs              [][]string        `mapstructure:"bhyve_args" required:"false"`
+	BootDetachISOAfter     int               `mapstructure:"boot_detach_iso_after" required:"false"`
+	BootSteps              [][]string        `mapstructure:"boot_steps" required:"false"`
+	BootTransport          string            `mapstructure:"boot_command_transport" required:"false"`
+	CloudInitMetaData      string            `mapstructure:"cloud_init_meta_data" required:"false"`
+	CloudInitNetworkConfig string            `mapstructure:"cloud_init_network_config" required:"false"`
+	CloudInitUserData      string            `mapstructure:"cloud_init_user_data" required:"false"`
+	CommConfig             CommConfig        `mapstructure:",squash"`
+	DiskCache              string            `mapstructure:"disk_cache" required:"false"`
+	DiskCompression        bool              `mapstructure:"disk_compression" required:"false"`
+	DiskImage              bool              `mapstructure:"disk_image" required:"false"`
+	DiskInterface          string            `mapstructure:"disk_interface" required:"false"`
+	DiskName               string            `mapstructure:"disk_name" required:"false"`
+	DiskReadOnly           bool              `mapstructure:"disk_read_only" required:"false"`
+	DiskSectorSize         string            `mapstructure:"disk_sector_size" required:"false"`
+	DiskSize               string            `mapstructure:"disk_size" required:"false"`
+	DiskSourceSnapshot     string            `mapstructure:"disk_source_snapshot" required:"false"`
+	DiskUseZVOL            bool              `mapstructure:"disk_use_zvol" required:"false"`
+	DiskZPool              string            `mapstructure:"disk_zpool" required:"false"`
+	EFIVars                string            `mapstructure:"efi_vars" required:"false"`
+	Firmware               string            `mapstructure:"firmware" required:"false"`
+	HostNIC                string            `mapstructure:"host_nic"`
+	MACAddress             string            `mapstructure:"mac_address" required:"false"`
+	MaxReboots             int               `mapstructure:"max_reboots" required:"false"`
+	MemorySize             string            `mapstructure:"memory" required:"false"`
+	MemoryWired            bool              `mapstructure:"memory_wired" required:"false"`
+	NetDevice              string            `mapstructure:"net_device" required:"false"`
+	OutputCompression      string            `mapstructure:"output_compression" required:"false"`
+	OutputDir              string            `mapstructure:"output_directory" required:"false"`
+	OutputFormat           []string          `mapstructure:"output_format" required:"false"`
+	PublishDataset         string            `mapstructure:"publish_dataset" required:"false"`
+	SerialLog              bool              `mapstructure:"serial_log" required:"false"`
+	SMBIOSSerial           string            `mapstructure:"smbios_serial" required:"false"`
+	TritonImage            TritonImageConfig `mapstructure:"triton_image" required:"false"`
+	VMName                 string            `mapstructure:"vm_name" required:"false"`
+	VMUUID                 string            `mapstructure:"vm_uuid" required:"false"`
+	VNCBindAddress         string            `mapstructure:"vnc_bind_address" required:"false"`
+	VNCPortMax             int               `mapstructure:"vnc_port_max"`
+	VNCPortMin             int               `mapstructure:"vnc_port_min" required:"false"`
+	VNCUsePassword         bool              `mapstructure:"vnc_use_password" required:"false"`
+	VNICCreate             bool              `mapstructure:"vnic_create" required:"false"`
+	VNICName               string            `mapstructure:"vnic_name" required:"false"`
+	VNICLink               string            `mapstructure:"vnic_link" required:"false"`
 
 	ctx interpolate.Context
 }
@@ -121,8 +215,14 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 		InterpolateContext: &c.ctx,
 		InterpolateFilter: &interpolate.RenderFilter{
 			Exclude: []string{
+				"bhyve_args",
 				"boot_command",
 				"boot_steps",
+				"cloud_init_meta_data",
+				"cloud_init_network_config",
+				"cloud_init_user_data",
+				"smbios_serial",
+				"vm_uuid",
 			},
 		},
 	}, raws...)
@@ -138,29 +238,233 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 	errs = packer.MultiErrorAppend(errs, isoErrs...)
 	errs = packer.MultiErrorAppend(errs, c.HTTPConfig.Prepare(&c.ctx)...)
 	errs = packer.MultiErrorAppend(errs, c.ShutdownConfig.Prepare(&c.ctx)...)
+	errs = packer.MultiErrorAppend(errs, c.GuestConfig.Prepare(&c.ctx)...)
+	errs = packer.MultiErrorAppend(errs, c.ZFSSendConfig.Prepare(&c.ctx)...)
+	errs = packer.MultiErrorAppend(errs, c.ZvolConfig.Prepare(&c.ctx, c)...)
 	ccWarn, ccErr := c.CommConfig.Prepare(&c.ctx)
 	if len(ccErr) > 0 {
 		errs = packer.MultiErrorAppend(errs, ccErr...)
 	}
 	warnings = append(warnings, ccWarn...)
 
+	if c.BootDetachISOAfter == 0 {
+		c.BootDetachISOAfter = 1
+	}
+
+	if c.BootDetachISOAfter < 0 {
+		errs = packer.MultiErrorAppend(
+			errs, fmt.Errorf("boot_detach_iso_after must be positive"))
+	}
+
+	if c.MaxReboots < 0 {
+		errs = packer.MultiErrorAppend(
+			errs, fmt.Errorf("max_reboots must be positive"))
+	}
+
+	if c.DiskCache == "" {
+		c.DiskCache = "writeback"
+	}
+
+	// Additional disks default to the same options as the boot disk.
+	if c.AdditionalCache == "" {
+		c.AdditionalCache = c.DiskCache
+	}
+	if c.AdditionalSectorSize == "" {
+		c.AdditionalSectorSize = c.DiskSectorSize
+	}
+
+	for name, cache := range map[string]string{
+		"disk_cache":            c.DiskCache,
+		"disk_additional_cache": c.AdditionalCache,
+	} {
+		switch cache {
+		case "writeback", "writethrough", "none", "directsync":
+		default:
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf(
+				"%s must be one of writeback, writethrough, none or directsync", name))
+		}
+	}
+
+	if c.DiskInterface == "" {
+		c.DiskInterface = "virtio-blk"
+	}
+
+	switch c.DiskInterface {
+	case "virtio-blk", "nvme", "ahci-hd":
+	default:
+		errs = packer.MultiErrorAppend(
+			errs, fmt.Errorf("disk_interface must be one of virtio-blk, nvme or ahci-hd"))
+	}
+
+	if c.DiskSectorSize != "" {
+		errs = packer.MultiErrorAppend(errs,
+			c.validateDiskSectorSize("disk_sector_size", c.DiskSectorSize)...)
+	}
+	if c.AdditionalSectorSize != "" && c.AdditionalSectorSize != c.DiskSectorSize {
+		errs = packer.MultiErrorAppend(errs,
+			c.validateDiskSectorSize("disk_additional_sector_size", c.AdditionalSectorSize)...)
+	}
+
 	if c.DiskName == "" {
 		c.DiskName = fmt.Sprintf("disk-%s", c.PackerBuildName)
 	}
 
-	if c.DiskSize == "" {
+	// A clone defaults to the size of its origin.
+	if c.DiskSize == "" && c.DiskSourceSnapshot == "" {
 		errs = packer.MultiErrorAppend(errs, fmt.Errorf("disk_size must be specified"))
+	} else if c.DiskImage || (c.DiskSize != "" && c.DiskSourceSnapshot != "") {
+		if _, err := parseDiskSize(c.DiskSize); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("disk_size: %s", err))
+		}
 	}
 
 	if c.DiskZPool == "" {
 		c.DiskZPool = "zones"
 	}
 
-	if c.MemorySize < 10 {
-		log.Printf("MemorySize %d is too small, using default: 512", c.MemorySize)
-		c.MemorySize = 512
+	if c.BaseSnapshot != "" && !c.DiskUseZVOL {
+		errs = packer.MultiErrorAppend(errs,
+			fmt.Errorf("zfs_send_base_snapshot requires disk_use_zvol"))
+	}
+
+	// An incremental stream can only be sent from a snapshot that the boot
+	// disk shares history with, which means one of the dataset it was
+	// cloned from.
+	if c.BaseSnapshot != "" && c.DiskUseZVOL {
+		base_dataset := strings.SplitN(c.BaseSnapshot, "@", 2)[0]
+		source_dataset := strings.SplitN(c.DiskSourceSnapshot, "@", 2)[0]
+		if c.DiskSourceSnapshot == "" || base_dataset != source_dataset {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf(
+				"zfs_send_base_snapshot requires disk_source_snapshot from the same dataset"))
+		}
 	}
 
+	if c.PublishDataset != "" {
+		if !c.DiskUseZVOL {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("publish_dataset requires disk_use_zvol"))
+		}
+		if !strings.Contains(c.PublishDataset, "/") || strings.Contains(c.PublishDataset, "@") {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("publish_dataset must be a dataset in a pool, for example zones/images/base"))
+		}
+	}
+
+	if c.DiskSourceSnapshot != "" {
+		if !c.DiskUseZVOL {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("disk_source_snapshot requires disk_use_zvol"))
+		}
+		if !strings.Contains(c.DiskSourceSnapshot, "@") {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("disk_source_snapshot must be a snapshot, for example zones/image@final"))
+		}
+		if c.DiskImage {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("disk_source_snapshot and disk_image cannot both be used"))
+		}
+	}
+
+	if c.Firmware == "" {
+		c.Firmware = "uefi"
+	}
+
+	switch c.Firmware {
+	case "uefi", "uefi-csm":
+	default:
+		if !filepath.IsAbs(c.Firmware) {
+			errs = packer.MultiErrorAppend(
+				errs, fmt.Errorf("firmware must be one of uefi, uefi-csm, or an absolute path to a firmware image"))
+		}
+	}
+
+	// Give the stock UEFI firmware its own copy of the variable template,
+	// so that boot entries are neither lost nor written to the shared one.
+	if c.EFIVars == "" && c.Firmware == "uefi" {
+		if _, err := os.Stat(defaultEFIVars); err == nil {
+			c.EFIVars = defaultEFIVars
+		} else {
+			log.Printf("No EFI variable template at %s, boot entries will not be kept",
+				defaultEFIVars)
+		}
+	}
+
+	if c.EFIVars != "" {
+		if _, err := os.Stat(c.EFIVars); err != nil {
+			errs = packer.MultiErrorAppend(
+				errs, fmt.Errorf("efi_vars template is not accessible: %s", err))
+		}
+	}
+
+	// The cloud-init seed shares the cd_files CD-ROM, which must then be
+	// labelled for NoCloud to find it.
+	if c.useCloudInit() {
+		if c.CDLabel != "" && c.CDLabel != cloudInitLabel {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf(
+				"cd_label must be %s or unset when using cloud-init", cloudInitLabel))
+		}
+		for name, value := range c.cloudInitFiles() {
+			if value == "" {
+				continue
+			}
+			if _, ok := c.CDContent[name]; ok {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf(
+					"cd_content must not contain %s when using cloud-init", name))
+			}
+		}
+	}
+
+	switch c.OutputCompression {
+	case "", "gzip", "zstd", "xz":
+	case "none":
+		c.OutputCompression = ""
+	default:
+		errs = packer.MultiErrorAppend(errs,
+			fmt.Errorf("output_compression must be one of none, gzip, zstd or xz"))
+	}
+
+	// zvols are sent as a ZFS stream by default, file disks are raw
+	// already.
+	if len(c.OutputFormat) == 0 {
+		if c.DiskUseZVOL {
+			c.OutputFormat = []string{"zfs"}
+		} else {
+			c.OutputFormat = []string{"raw"}
+		}
+	}
+	formats := map[string]bool{}
+	for _, format := range c.OutputFormat {
+		switch format {
+		case "zfs":
+			if !c.DiskUseZVOL {
+				errs = packer.MultiErrorAppend(errs,
+					fmt.Errorf("output_format zfs requires disk_use_zvol"))
+			}
+		case "raw", "qcow2", "vmdk":
+		default:
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("output_format must be a list of zfs, raw, qcow2 or vmdk"))
+		}
+		if formats[format] {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("output_format %s is listed more than once", format))
+		}
+		formats[format] = true
+	}
+
+	// Normalise the memory size to MiB.
+	if c.MemorySize == "" {
+		c.MemorySize = "512"
+	}
+
+	if mib, err := parseMemorySize(c.MemorySize); err != nil {
+		errs = packer.MultiErrorAppend(errs, err)
+	} else {
+		c.MemorySize = strconv.Itoa(mib)
+	}
+
+	errs = packer.MultiErrorAppend(errs, c.CPUConfig.validatePinning()...)
+
 	if c.OutputDir == "" {
 		c.OutputDir = fmt.Sprintf("output-%s", c.PackerBuildName)
 	}
@@ -176,6 +480,34 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 		c.VMName = fmt.Sprintf("packer-%s", c.PackerBuildName)
 	}
 
+	// Derive the UUID from the VM name so that it does not change from
+	// one build to the next.
+	if c.VMUUID == "" {
+		c.VMUUID = uuid.NewSHA1(vmUUIDNamespace, []byte(c.VMName)).String()
+	} else if !strings.Contains(c.VMUUID, "{{") {
+		if _, err := uuid.Parse(c.VMUUID); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("invalid vm_uuid: %s", err))
+		}
+	}
+
+	if c.BootTransport == "" {
+		c.BootTransport = "vnc"
+	}
+
+	switch c.BootTransport {
+	case "vnc", "serial":
+	default:
+		errs = packer.MultiErrorAppend(
+			errs, fmt.Errorf("boot_command_transport must be one of vnc or serial"))
+	}
+
+	for i, step := range c.BootSteps {
+		if _, err := parseBootStep(step); err != nil {
+			errs = packer.MultiErrorAppend(
+				errs, fmt.Errorf("boot_steps[%d]: %s", i, err))
+		}
+	}
+
 	if c.VNCBindAddress == "" {
 		c.VNCBindAddress = "127.0.0.1"
 	}
@@ -189,7 +521,15 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 	}
 
 	errs = packer.MultiErrorAppend(errs, c.CDConfig.Prepare(&c.ctx)...)
-	errs = packer.MultiErrorAppend(errs, c.VNCConfig.Prepare(&c.ctx)...)
+
+	// VNCConfig.Prepare rejects a boot command without VNC, which is
+	// exactly what the serial transport is for, so only the boot command
+	// itself is checked for it.
+	if c.BootTransport == "serial" {
+		errs = packer.MultiErrorAppend(errs, c.VNCConfig.BootConfig.Prepare(&c.ctx)...)
+	} else {
+		errs = packer.MultiErrorAppend(errs, c.VNCConfig.Prepare(&c.ctx)...)
+	}
 
 	if c.VNCPortMin < 5900 {
 		errs = packer.MultiErrorAppend(
@@ -214,9 +554,168 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 		c.VNICName = "packer0"
 	}
 
+	if c.NetDevice == "" {
+		c.NetDevice = "virtio-net-viona"
+	}
+
+	errs = packer.MultiErrorAppend(errs,
+		validateNIC("net_device", c.NetDevice, c.MACAddress, c.VNICCreate)...)
+
+	for i := range c.AdditionalNICs {
+		errs = packer.MultiErrorAppend(errs,
+			c.AdditionalNICs[i].Prepare(&c.ctx, c, i)...)
+	}
+
+	errs = packer.MultiErrorAppend(errs, c.TritonImage.Prepare(&c.ctx, c)...)
+
+	errs = packer.MultiErrorAppend(errs, c.validateTritonManifest()...)
+
+	errs = packer.MultiErrorAppend(errs, c.validateBhyveArgs()...)
+
+	if len(c.AdditionalDiskSize)+len(c.AdditionalNICs) > len(c.freePCISlots()) {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf(
+			"disk_additional_size and additional_nics support at most %d devices in total",
+			len(c.freePCISlots())))
+	}
+
 	if errs != nil && len(errs.Errors) > 0 {
 		return warnings, errs
 	}
 
 	return warnings, nil
 }
+
+// useSerialConsole returns whether the guest com1 port needs to be attached
+// to a socket, either for logging, for typing boot commands, or for boot
+// steps that wait for console output.
+func (c *Config) useSerialConsole() bool {
+	if c.SerialLog || c.BootTransport == "serial" {
+		return true
+	}
+
+	for _, step := range c.BootSteps {
+		if len(step) >= 3 && step[2] != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The smallest memory size, in MiB, that a guest is given.
+const minMemorySize = 10
+
+// parseMemorySize parses a memory size in MiB, or with a K, M, G or T
+// suffix and an optional B, and returns it in MiB.  Sizes that are not a
+// whole number of MiB or are below minMemorySize are an error.
+func parseMemorySize(size string) (int, error) {
+	size = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B")
+
+	multiplier := 0
+	shift := map[byte]int{'K': -10, 'M': 0, 'G': 10, 'T': 20}
+	if n := len(size); n > 0 {
+		if s, ok := shift[size[n-1]]; ok {
+			multiplier = s
+			size = size[:n-1]
+		}
+	}
+
+	v, err := strconv.Atoi(size)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("memory must be a size in MiB, or have a K, M, G or T suffix")
+	}
+
+	var mib int
+	switch {
+	case multiplier < 0:
+		if v%(1<<-multiplier) != 0 {
+			return 0, fmt.Errorf("memory must be a whole number of MiB")
+		}
+		mib = v >> -multiplier
+	case v > math.MaxInt32>>multiplier:
+		return 0, fmt.Errorf("memory is too large")
+	default:
+		mib = v << multiplier
+	}
+
+	if mib < minMemorySize {
+		return 0, fmt.Errorf("memory must be at least %d MiB", minMemorySize)
+	}
+
+	return mib, nil
+}
+
+// additionalDiskName returns the zvol or file name for the i'th entry of
+// disk_additional_size.
+func (c *Config) additionalDiskName(i int) string {
+	return fmt.Sprintf("%s-%d", c.DiskName, i+1)
+}
+
+// validateDiskSectorSize checks the sector size option name, which is either
+// a logical sector size or logical/physical, against what the disk_interface
+// supports.
+func (c *Config) validateDiskSectorSize(name string, sector_size string) []error {
+	var errs []error
+
+	sizes := strings.Split(sector_size, "/")
+	if len(sizes) > 2 {
+		return append(errs, fmt.Errorf("%s must be of the form logical or logical/physical", name))
+	}
+
+	values := []int{}
+	for _, size := range sizes {
+		v, err := strconv.Atoi(size)
+		if err != nil || v < 512 || v&(v-1) != 0 {
+			return append(errs, fmt.Errorf("%s %q is not a power of 2 of at least 512", name, size))
+		}
+		values = append(values, v)
+	}
+
+	if len(values) == 2 && values[1] < values[0] {
+		errs = append(errs, fmt.Errorf("%s physical size must not be smaller than the logical size", name))
+	}
+
+	if c.DiskInterface == "nvme" {
+		if len(values) == 2 {
+			errs = append(errs, fmt.Errorf("%s cannot set a physical sector size with nvme", name))
+		}
+		switch values[0] {
+		case 512, 4096, 8192:
+		default:
+			errs = append(errs, fmt.Errorf("%s must be one of 512, 4096 or 8192 with nvme", name))
+		}
+	}
+
+	return errs
+}
+
+// bootDiskOptions returns the blockif options for the boot disk.
+func (c *Config) bootDiskOptions() diskOptions {
+	return diskOptions{
+		cache:      c.DiskCache,
+		sectorSize: c.DiskSectorSize,
+		readOnly:   c.DiskReadOnly,
+	}
+}
+
+// additionalDiskOptions returns the blockif options for the disks in
+// disk_additional_size.
+func (c *Config) additionalDiskOptions() diskOptions {
+	return diskOptions{
+		cache:      c.AdditionalCache,
+		sectorSize: c.AdditionalSectorSize,
+		readOnly:   c.AdditionalReadOnly,
+	}
+}
+
+// firmwarePath returns the path to the bootrom for the configured firmware.
+func (c *Config) firmwarePath() string {
+	switch c.Firmware {
+	case "uefi":
+		return "/usr/share/bhyve/uefi-rom.bin"
+	case "uefi-csm":
+		return "/usr/share/bhyve/uefi-csm-rom.bin"
+	default:
+		return c.Firmware
+	}
+}
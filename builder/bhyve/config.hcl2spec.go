@@ -10,106 +10,154 @@ import (
 // FlatConfig is an auto-generated flat version of Config.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatConfig struct {
-	PackerBuildName           *string           `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
-	PackerBuilderType         *string           `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
-	PackerCoreVersion         *string           `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
-	PackerDebug               *bool             `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
-	PackerForce               *bool             `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
-	PackerOnError             *string           `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
-	PackerUserVars            map[string]string `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
-	PackerSensitiveVars       []string          `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
-	HTTPDir                   *string           `mapstructure:"http_directory" cty:"http_directory" hcl:"http_directory"`
-	HTTPContent               map[string]string `mapstructure:"http_content" cty:"http_content" hcl:"http_content"`
-	HTTPPortMin               *int              `mapstructure:"http_port_min" cty:"http_port_min" hcl:"http_port_min"`
-	HTTPPortMax               *int              `mapstructure:"http_port_max" cty:"http_port_max" hcl:"http_port_max"`
-	HTTPAddress               *string           `mapstructure:"http_bind_address" cty:"http_bind_address" hcl:"http_bind_address"`
-	HTTPInterface             *string           `mapstructure:"http_interface" undocumented:"true" cty:"http_interface" hcl:"http_interface"`
-	ISOChecksum               *string           `mapstructure:"iso_checksum" required:"true" cty:"iso_checksum" hcl:"iso_checksum"`
-	RawSingleISOUrl           *string           `mapstructure:"iso_url" required:"true" cty:"iso_url" hcl:"iso_url"`
-	ISOUrls                   []string          `mapstructure:"iso_urls" cty:"iso_urls" hcl:"iso_urls"`
-	TargetPath                *string           `mapstructure:"iso_target_path" cty:"iso_target_path" hcl:"iso_target_path"`
-	TargetExtension           *string           `mapstructure:"iso_target_extension" cty:"iso_target_extension" hcl:"iso_target_extension"`
-	CDFiles                   []string          `mapstructure:"cd_files" cty:"cd_files" hcl:"cd_files"`
-	CDContent                 map[string]string `mapstructure:"cd_content" cty:"cd_content" hcl:"cd_content"`
-	CDLabel                   *string           `mapstructure:"cd_label" cty:"cd_label" hcl:"cd_label"`
-	BootGroupInterval         *string           `mapstructure:"boot_keygroup_interval" cty:"boot_keygroup_interval" hcl:"boot_keygroup_interval"`
-	BootWait                  *string           `mapstructure:"boot_wait" cty:"boot_wait" hcl:"boot_wait"`
-	BootCommand               []string          `mapstructure:"boot_command" cty:"boot_command" hcl:"boot_command"`
-	DisableVNC                *bool             `mapstructure:"disable_vnc" cty:"disable_vnc" hcl:"disable_vnc"`
-	BootKeyInterval           *string           `mapstructure:"boot_key_interval" cty:"boot_key_interval" hcl:"boot_key_interval"`
-	ShutdownCommand           *string           `mapstructure:"shutdown_command" required:"false" cty:"shutdown_command" hcl:"shutdown_command"`
-	ShutdownTimeout           *string           `mapstructure:"shutdown_timeout" required:"false" cty:"shutdown_timeout" hcl:"shutdown_timeout"`
-	CpuCount                  *int              `mapstructure:"cpus" required:"false" cty:"cpus" hcl:"cpus"`
-	SocketCount               *int              `mapstructure:"sockets" required:"false" cty:"sockets" hcl:"sockets"`
-	CoreCount                 *int              `mapstructure:"cores" required:"false" cty:"cores" hcl:"cores"`
-	ThreadCount               *int              `mapstructure:"threads" required:"false" cty:"threads" hcl:"threads"`
-	BootSteps                 [][]string        `mapstructure:"boot_steps" required:"false" cty:"boot_steps" hcl:"boot_steps"`
-	Type                      *string           `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
-	PauseBeforeConnect        *string           `mapstructure:"pause_before_connecting" cty:"pause_before_connecting" hcl:"pause_before_connecting"`
-	SSHHost                   *string           `mapstructure:"ssh_host" cty:"ssh_host" hcl:"ssh_host"`
-	SSHPort                   *int              `mapstructure:"ssh_port" cty:"ssh_port" hcl:"ssh_port"`
-	SSHUsername               *string           `mapstructure:"ssh_username" cty:"ssh_username" hcl:"ssh_username"`
-	SSHPassword               *string           `mapstructure:"ssh_password" cty:"ssh_password" hcl:"ssh_password"`
-	SSHKeyPairName            *string           `mapstructure:"ssh_keypair_name" undocumented:"true" cty:"ssh_keypair_name" hcl:"ssh_keypair_name"`
-	SSHTemporaryKeyPairName   *string           `mapstructure:"temporary_key_pair_name" undocumented:"true" cty:"temporary_key_pair_name" hcl:"temporary_key_pair_name"`
-	SSHTemporaryKeyPairType   *string           `mapstructure:"temporary_key_pair_type" cty:"temporary_key_pair_type" hcl:"temporary_key_pair_type"`
-	SSHTemporaryKeyPairBits   *int              `mapstructure:"temporary_key_pair_bits" cty:"temporary_key_pair_bits" hcl:"temporary_key_pair_bits"`
-	SSHCiphers                []string          `mapstructure:"ssh_ciphers" cty:"ssh_ciphers" hcl:"ssh_ciphers"`
-	SSHClearAuthorizedKeys    *bool             `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
-	SSHKEXAlgos               []string          `mapstructure:"ssh_key_exchange_algorithms" cty:"ssh_key_exchange_algorithms" hcl:"ssh_key_exchange_algorithms"`
-	SSHPrivateKeyFile         *string           `mapstructure:"ssh_private_key_file" undocumented:"true" cty:"ssh_private_key_file" hcl:"ssh_private_key_file"`
-	SSHCertificateFile        *string           `mapstructure:"ssh_certificate_file" cty:"ssh_certificate_file" hcl:"ssh_certificate_file"`
-	SSHPty                    *bool             `mapstructure:"ssh_pty" cty:"ssh_pty" hcl:"ssh_pty"`
-	SSHTimeout                *string           `mapstructure:"ssh_timeout" cty:"ssh_timeout" hcl:"ssh_timeout"`
-	SSHWaitTimeout            *string           `mapstructure:"ssh_wait_timeout" undocumented:"true" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
-	SSHAgentAuth              *bool             `mapstructure:"ssh_agent_auth" undocumented:"true" cty:"ssh_agent_auth" hcl:"ssh_agent_auth"`
-	SSHDisableAgentForwarding *bool             `mapstructure:"ssh_disable_agent_forwarding" cty:"ssh_disable_agent_forwarding" hcl:"ssh_disable_agent_forwarding"`
-	SSHHandshakeAttempts      *int              `mapstructure:"ssh_handshake_attempts" cty:"ssh_handshake_attempts" hcl:"ssh_handshake_attempts"`
-	SSHBastionHost            *string           `mapstructure:"ssh_bastion_host" cty:"ssh_bastion_host" hcl:"ssh_bastion_host"`
-	SSHBastionPort            *int              `mapstructure:"ssh_bastion_port" cty:"ssh_bastion_port" hcl:"ssh_bastion_port"`
-	SSHBastionAgentAuth       *bool             `mapstructure:"ssh_bastion_agent_auth" cty:"ssh_bastion_agent_auth" hcl:"ssh_bastion_agent_auth"`
-	SSHBastionUsername        *string           `mapstructure:"ssh_bastion_username" cty:"ssh_bastion_username" hcl:"ssh_bastion_username"`
-	SSHBastionPassword        *string           `mapstructure:"ssh_bastion_password" cty:"ssh_bastion_password" hcl:"ssh_bastion_password"`
-	SSHBastionInteractive     *bool             `mapstructure:"ssh_bastion_interactive" cty:"ssh_bastion_interactive" hcl:"ssh_bastion_interactive"`
-	SSHBastionPrivateKeyFile  *string           `mapstructure:"ssh_bastion_private_key_file" cty:"ssh_bastion_private_key_file" hcl:"ssh_bastion_private_key_file"`
-	SSHBastionCertificateFile *string           `mapstructure:"ssh_bastion_certificate_file" cty:"ssh_bastion_certificate_file" hcl:"ssh_bastion_certificate_file"`
-	SSHFileTransferMethod     *string           `mapstructure:"ssh_file_transfer_method" cty:"ssh_file_transfer_method" hcl:"ssh_file_transfer_method"`
-	SSHProxyHost              *string           `mapstructure:"ssh_proxy_host" cty:"ssh_proxy_host" hcl:"ssh_proxy_host"`
-	SSHProxyPort              *int              `mapstructure:"ssh_proxy_port" cty:"ssh_proxy_port" hcl:"ssh_proxy_port"`
-	SSHProxyUsername          *string           `mapstructure:"ssh_proxy_username" cty:"ssh_proxy_username" hcl:"ssh_proxy_username"`
-	SSHProxyPassword          *string           `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
-	SSHKeepAliveInterval      *string           `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
-	SSHReadWriteTimeout       *string           `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
-	SSHRemoteTunnels          []string          `mapstructure:"ssh_remote_tunnels" cty:"ssh_remote_tunnels" hcl:"ssh_remote_tunnels"`
-	SSHLocalTunnels           []string          `mapstructure:"ssh_local_tunnels" cty:"ssh_local_tunnels" hcl:"ssh_local_tunnels"`
-	SSHPublicKey              []byte            `mapstructure:"ssh_public_key" undocumented:"true" cty:"ssh_public_key" hcl:"ssh_public_key"`
-	SSHPrivateKey             []byte            `mapstructure:"ssh_private_key" undocumented:"true" cty:"ssh_private_key" hcl:"ssh_private_key"`
-	WinRMUser                 *string           `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
-	WinRMPassword             *string           `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
-	WinRMHost                 *string           `mapstructure:"winrm_host" cty:"winrm_host" hcl:"winrm_host"`
-	WinRMNoProxy              *bool             `mapstructure:"winrm_no_proxy" cty:"winrm_no_proxy" hcl:"winrm_no_proxy"`
-	WinRMPort                 *int              `mapstructure:"winrm_port" cty:"winrm_port" hcl:"winrm_port"`
-	WinRMTimeout              *string           `mapstructure:"winrm_timeout" cty:"winrm_timeout" hcl:"winrm_timeout"`
-	WinRMUseSSL               *bool             `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
-	WinRMInsecure             *bool             `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
-	WinRMUseNTLM              *bool             `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
-	HostPortMin               *int              `mapstructure:"host_port_min" required:"false" cty:"host_port_min" hcl:"host_port_min"`
-	HostPortMax               *int              `mapstructure:"host_port_max" required:"false" cty:"host_port_max" hcl:"host_port_max"`
-	DiskName                  *string           `mapstructure:"disk_name" required:"false" cty:"disk_name" hcl:"disk_name"`
-	DiskSize                  *string           `mapstructure:"disk_size" required:"false" cty:"disk_size" hcl:"disk_size"`
-	DiskUseZVOL               *bool             `mapstructure:"disk_use_zvol" required:"false" cty:"disk_use_zvol" hcl:"disk_use_zvol"`
-	DiskZPool                 *string           `mapstructure:"disk_zpool" required:"false" cty:"disk_zpool" hcl:"disk_zpool"`
-	HostNIC                   *string           `mapstructure:"host_nic" cty:"host_nic" hcl:"host_nic"`
-	MemorySize                *int              `mapstructure:"memory" required:"false" cty:"memory" hcl:"memory"`
-	OutputDir                 *string           `mapstructure:"output_directory" required:"false" cty:"output_directory" hcl:"output_directory"`
-	VMName                    *string           `mapstructure:"vm_name" required:"false" cty:"vm_name" hcl:"vm_name"`
-	VNCBindAddress            *string           `mapstructure:"vnc_bind_address" required:"false" cty:"vnc_bind_address" hcl:"vnc_bind_address"`
-	VNCPortMax                *int              `mapstructure:"vnc_port_max" cty:"vnc_port_max" hcl:"vnc_port_max"`
-	VNCPortMin                *int              `mapstructure:"vnc_port_min" required:"false" cty:"vnc_port_min" hcl:"vnc_port_min"`
-	VNCUsePassword            *bool             `mapstructure:"vnc_use_password" required:"false" cty:"vnc_use_password" hcl:"vnc_use_password"`
-	VNICCreate                *bool             `mapstructure:"vnic_create" required:"false" cty:"vnic_create" hcl:"vnic_create"`
-	VNICName                  *string           `mapstructure:"vnic_name" required:"false" cty:"vnic_name" hcl:"vnic_name"`
-	VNICLink                  *string           `mapstructure:"vnic_link" required:"false" cty:"vnic_link" hcl:"vnic_link"`
+	PackerBuildName           *string                `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
+	PackerBuilderType         *string                `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
+	PackerCoreVersion         *string                `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
+	PackerDebug               *bool                  `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
+	PackerForce               *bool                  `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
+	PackerOnError             *string                `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
+	PackerUserVars            map[string]string      `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
+	PackerSensitiveVars       []string               `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
+	HTTPDir                   *string                `mapstructure:"http_directory" cty:"http_directory" hcl:"http_directory"`
+	HTTPContent               map[string]string      `mapstructure:"http_content" cty:"http_content" hcl:"http_content"`
+	HTTPPortMin               *int                   `mapstructure:"http_port_min" cty:"http_port_min" hcl:"http_port_min"`
+	HTTPPortMax               *int                   `mapstructure:"http_port_max" cty:"http_port_max" hcl:"http_port_max"`
+	HTTPAddress               *string                `mapstructure:"http_bind_address" cty:"http_bind_address" hcl:"http_bind_address"`
+	HTTPInterface             *string                `mapstructure:"http_interface" undocumented:"true" cty:"http_interface" hcl:"http_interface"`
+	ISOChecksum               *string                `mapstructure:"iso_checksum" required:"true" cty:"iso_checksum" hcl:"iso_checksum"`
+	RawSingleISOUrl           *string                `mapstructure:"iso_url" required:"true" cty:"iso_url" hcl:"iso_url"`
+	ISOUrls                   []string               `mapstructure:"iso_urls" cty:"iso_urls" hcl:"iso_urls"`
+	TargetPath                *string                `mapstructure:"iso_target_path" cty:"iso_target_path" hcl:"iso_target_path"`
+	TargetExtension           *string                `mapstructure:"iso_target_extension" cty:"iso_target_extension" hcl:"iso_target_extension"`
+	CDFiles                   []string               `mapstructure:"cd_files" cty:"cd_files" hcl:"cd_files"`
+	CDContent                 map[string]string      `mapstructure:"cd_content" cty:"cd_content" hcl:"cd_content"`
+	CDLabel                   *string                `mapstructure:"cd_label" cty:"cd_label" hcl:"cd_label"`
+	BootGroupInterval         *string                `mapstructure:"boot_keygroup_interval" cty:"boot_keygroup_interval" hcl:"boot_keygroup_interval"`
+	BootWait                  *string                `mapstructure:"boot_wait" cty:"boot_wait" hcl:"boot_wait"`
+	BootCommand               []string               `mapstructure:"boot_command" cty:"boot_command" hcl:"boot_command"`
+	DisableVNC                *bool                  `mapstructure:"disable_vnc" cty:"disable_vnc" hcl:"disable_vnc"`
+	BootKeyInterval           *string                `mapstructure:"boot_key_interval" cty:"boot_key_interval" hcl:"boot_key_interval"`
+	ShutdownCommand           *string                `mapstructure:"shutdown_command" required:"false" cty:"shutdown_command" hcl:"shutdown_command"`
+	ShutdownTimeout           *string                `mapstructure:"shutdown_timeout" required:"false" cty:"shutdown_timeout" hcl:"shutdown_timeout"`
+	CpuCount                  *int                   `mapstructure:"cpus" required:"false" cty:"cpus" hcl:"cpus"`
+	SocketCount               *int                   `mapstructure:"sockets" required:"false" cty:"sockets" hcl:"sockets"`
+	CoreCount                 *int                   `mapstructure:"cores" required:"false" cty:"cores" hcl:"cores"`
+	ThreadCount               *int                   `mapstructure:"threads" required:"false" cty:"threads" hcl:"threads"`
+	CPUPinning                map[string]int         `mapstructure:"cpu_pinning" required:"false" cty:"cpu_pinning" hcl:"cpu_pinning"`
+	GuestOSType               *string                `mapstructure:"guest_os_type" required:"false" cty:"guest_os_type" hcl:"guest_os_type"`
+	ACPI                      *bool                  `mapstructure:"acpi" required:"false" cty:"acpi" hcl:"acpi"`
+	DisableMPTable            *bool                  `mapstructure:"disable_mptable" required:"false" cty:"disable_mptable" hcl:"disable_mptable"`
+	IgnoreMSRs                *bool                  `mapstructure:"ignore_unimplemented_msr" required:"false" cty:"ignore_unimplemented_msr" hcl:"ignore_unimplemented_msr"`
+	RTCUTC                    *bool                  `mapstructure:"rtc_utc" required:"false" cty:"rtc_utc" hcl:"rtc_utc"`
+	X2APIC                    *bool                  `mapstructure:"x2apic" required:"false" cty:"x2apic" hcl:"x2apic"`
+	BaseSnapshot              *string                `mapstructure:"zfs_send_base_snapshot" required:"false" cty:"zfs_send_base_snapshot" hcl:"zfs_send_base_snapshot"`
+	Compressed                *bool                  `mapstructure:"zfs_send_compressed" required:"false" cty:"zfs_send_compressed" hcl:"zfs_send_compressed"`
+	Embedded                  *bool                  `mapstructure:"zfs_send_embedded" required:"false" cty:"zfs_send_embedded" hcl:"zfs_send_embedded"`
+	Intermediates             *bool                  `mapstructure:"zfs_send_intermediates" required:"false" cty:"zfs_send_intermediates" hcl:"zfs_send_intermediates"`
+	LargeBlocks               *bool                  `mapstructure:"zfs_send_large_blocks" required:"false" cty:"zfs_send_large_blocks" hcl:"zfs_send_large_blocks"`
+	Raw                       *bool                  `mapstructure:"zfs_send_raw" required:"false" cty:"zfs_send_raw" hcl:"zfs_send_raw"`
+	Compression               *string                `mapstructure:"disk_zvol_compression" required:"false" cty:"disk_zvol_compression" hcl:"disk_zvol_compression"`
+	Properties                map[string]string      `mapstructure:"disk_zvol_properties" required:"false" cty:"disk_zvol_properties" hcl:"disk_zvol_properties"`
+	Sparse                    *bool                  `mapstructure:"disk_zvol_sparse" required:"false" cty:"disk_zvol_sparse" hcl:"disk_zvol_sparse"`
+	Volblocksize              *string                `mapstructure:"disk_zvol_volblocksize" required:"false" cty:"disk_zvol_volblocksize" hcl:"disk_zvol_volblocksize"`
+	AdditionalDiskSize        []string               `mapstructure:"disk_additional_size" required:"false" cty:"disk_additional_size" hcl:"disk_additional_size"`
+	AdditionalCache           *string                `mapstructure:"disk_additional_cache" required:"false" cty:"disk_additional_cache" hcl:"disk_additional_cache"`
+	AdditionalReadOnly        *bool                  `mapstructure:"disk_additional_read_only" required:"false" cty:"disk_additional_read_only" hcl:"disk_additional_read_only"`
+	AdditionalSectorSize      *string                `mapstructure:"disk_additional_sector_size" required:"false" cty:"disk_additional_sector_size" hcl:"disk_additional_sector_size"`
+	AdditionalNICs            []FlatNICConfig        `mapstructure:"additional_nics" required:"false" cty:"additional_nics" hcl:"additional_nics"`
+	BhyveArgs                 [][]string             `mapstructure:"bhyve_args" required:"false" cty:"bhyve_args" hcl:"bhyve_args"`
+	BootDetachISOAfter        *int                   `mapstructure:"boot_detach_iso_after" required:"false" cty:"boot_detach_iso_after" hcl:"boot_detach_iso_after"`
+	BootSteps                 [][]string             `mapstructure:"boot_steps" required:"false" cty:"boot_steps" hcl:"boot_steps"`
+	BootTransport             *string                `mapstructure:"boot_command_transport" required:"false" cty:"boot_command_transport" hcl:"boot_command_transport"`
+	CloudInitMetaData         *string                `mapstructure:"cloud_init_meta_data" required:"false" cty:"cloud_init_meta_data" hcl:"cloud_init_meta_data"`
+	CloudInitNetworkConfig    *string                `mapstructure:"cloud_init_network_config" required:"false" cty:"cloud_init_network_config" hcl:"cloud_init_network_config"`
+	CloudInitUserData         *string                `mapstructure:"cloud_init_user_data" required:"false" cty:"cloud_init_user_data" hcl:"cloud_init_user_data"`
+	Type                      *string                `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
+	PauseBeforeConnect        *string                `mapstructure:"pause_before_connecting" cty:"pause_before_connecting" hcl:"pause_before_connecting"`
+	SSHHost                   *string                `mapstructure:"ssh_host" cty:"ssh_host" hcl:"ssh_host"`
+	SSHPort                   *int                   `mapstructure:"ssh_port" cty:"ssh_port" hcl:"ssh_port"`
+	SSHUsername               *string                `mapstructure:"ssh_username" cty:"ssh_username" hcl:"ssh_username"`
+	SSHPassword               *string                `mapstructure:"ssh_password" cty:"ssh_password" hcl:"ssh_password"`
+	SSHKeyPairName            *string                `mapstructure:"ssh_keypair_name" undocumented:"true" cty:"ssh_keypair_name" hcl:"ssh_keypair_name"`
+	SSHTemporaryKeyPairName   *string                `mapstructure:"temporary_key_pair_name" undocumented:"true" cty:"temporary_key_pair_name" hcl:"temporary_key_pair_name"`
+	SSHTemporaryKeyPairType   *string                `mapstructure:"temporary_key_pair_type" cty:"temporary_key_pair_type" hcl:"temporary_key_pair_type"`
+	SSHTemporaryKeyPairBits   *int                   `mapstructure:"temporary_key_pair_bits" cty:"temporary_key_pair_bits" hcl:"temporary_key_pair_bits"`
+	SSHCiphers                []string               `mapstructure:"ssh_ciphers" cty:"ssh_ciphers" hcl:"ssh_ciphers"`
+	SSHClearAuthorizedKeys    *bool                  `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
+	SSHKEXAlgos               []string               `mapstructure:"ssh_key_exchange_algorithms" cty:"ssh_key_exchange_algorithms" hcl:"ssh_key_exchange_algorithms"`
+	SSHPrivateKeyFile         *string                `mapstructure:"ssh_private_key_file" undocumented:"true" cty:"ssh_private_key_file" hcl:"ssh_private_key_file"`
+	SSHCertificateFile        *string                `mapstructure:"ssh_certificate_file" cty:"ssh_certificate_file" hcl:"ssh_certificate_file"`
+	SSHPty                    *bool                  `mapstructure:"ssh_pty" cty:"ssh_pty" hcl:"ssh_pty"`
+	SSHTimeout                *string                `mapstructure:"ssh_timeout" cty:"ssh_timeout" hcl:"ssh_timeout"`
+	SSHWaitTimeout            *string                `mapstructure:"ssh_wait_timeout" undocumented:"true" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
+	SSHAgentAuth              *bool                  `mapstructure:"ssh_agent_auth" undocumented:"true" cty:"ssh_agent_auth" hcl:"ssh_agent_auth"`
+	SSHDisableAgentForwarding *bool                  `mapstructure:"ssh_disable_agent_forwarding" cty:"ssh_disable_agent_forwarding" hcl:"ssh_disable_agent_forwarding"`
+	SSHHandshakeAttempts      *int                   `mapstructure:"ssh_handshake_attempts" cty:"ssh_handshake_attempts" hcl:"ssh_handshake_attempts"`
+	SSHBastionHost            *string                `mapstructure:"ssh_bastion_host" cty:"ssh_bastion_host" hcl:"ssh_bastion_host"`
+	SSHBastionPort            *int                   `mapstructure:"ssh_bastion_port" cty:"ssh_bastion_port" hcl:"ssh_bastion_port"`
+	SSHBastionAgentAuth       *bool                  `mapstructure:"ssh_bastion_agent_auth" cty:"ssh_bastion_agent_auth" hcl:"ssh_bastion_agent_auth"`
+	SSHBastionUsername        *string                `mapstructure:"ssh_bastion_username" cty:"ssh_bastion_username" hcl:"ssh_bastion_username"`
+	SSHBastionPassword        *string                `mapstructure:"ssh_bastion_password" cty:"ssh_bastion_password" hcl:"ssh_bastion_password"`
+	SSHBastionInteractive     *bool                  `mapstructure:"ssh_bastion_interactive" cty:"ssh_bastion_interactive" hcl:"ssh_bastion_interactive"`
+	SSHBastionPrivateKeyFile  *string                `mapstructure:"ssh_bastion_private_key_file" cty:"ssh_bastion_private_key_file" hcl:"ssh_bastion_private_key_file"`
+	SSHBastionCertificateFile *string                `mapstructure:"ssh_bastion_certificate_file" cty:"ssh_bastion_certificate_file" hcl:"ssh_bastion_certificate_file"`
+	SSHFileTransferMethod     *string                `mapstructure:"ssh_file_transfer_method" cty:"ssh_file_transfer_method" hcl:"ssh_file_transfer_method"`
+	SSHProxyHost              *string                `mapstructure:"ssh_proxy_host" cty:"ssh_proxy_host" hcl:"ssh_proxy_host"`
+	SSHProxyPort              *int                   `mapstructure:"ssh_proxy_port" cty:"ssh_proxy_port" hcl:"ssh_proxy_port"`
+	SSHProxyUsername          *string                `mapstructure:"ssh_proxy_username" cty:"ssh_proxy_username" hcl:"ssh_proxy_username"`
+	SSHProxyPassword          *string                `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
+	SSHKeepAliveInterval      *string                `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
+	SSHReadWriteTimeout       *string                `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
+	SSHRemoteTunnels          []string               `mapstructure:"ssh_remote_tunnels" cty:"ssh_remote_tunnels" hcl:"ssh_remote_tunnels"`
+	SSHLocalTunnels           []string               `mapstructure:"ssh_local_tunnels" cty:"ssh_local_tunnels" hcl:"ssh_local_tunnels"`
+	SSHPublicKey              []byte                 `mapstructure:"ssh_public_key" undocumented:"true" cty:"ssh_public_key" hcl:"ssh_public_key"`
+	SSHPrivateKey             []byte                 `mapstructure:"ssh_private_key" undocumented:"true" cty:"ssh_private_key" hcl:"ssh_private_key"`
+	WinRMUser                 *string                `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
+	WinRMPassword             *string                `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
+	WinRMHost                 *string                `mapstructure:"winrm_host" cty:"winrm_host" hcl:"winrm_host"`
+	WinRMNoProxy              *bool                  `mapstructure:"winrm_no_proxy" cty:"winrm_no_proxy" hcl:"winrm_no_proxy"`
+	WinRMPort                 *int                   `mapstructure:"winrm_port" cty:"winrm_port" hcl:"winrm_port"`
+	WinRMTimeout              *string                `mapstructure:"winrm_timeout" cty:"winrm_timeout" hcl:"winrm_timeout"`
+	WinRMUseSSL               *bool                  `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
+	WinRMInsecure             *bool                  `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
+	WinRMUseNTLM              *bool                  `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
+	HostPortMin               *int                   `mapstructure:"host_port_min" required:"false" cty:"host_port_min" hcl:"host_port_min"`
+	HostPortMax               *int                   `mapstructure:"host_port_max" required:"false" cty:"host_port_max" hcl:"host_port_max"`
+	DiskCache                 *string                `mapstructure:"disk_cache" required:"false" cty:"disk_cache" hcl:"disk_cache"`
+	DiskCompression           *bool                  `mapstructure:"disk_compression" required:"false" cty:"disk_compression" hcl:"disk_compression"`
+	DiskImage                 *bool                  `mapstructure:"disk_image" required:"false" cty:"disk_image" hcl:"disk_image"`
+	DiskInterface             *string                `mapstructure:"disk_interface" required:"false" cty:"disk_interface" hcl:"disk_interface"`
+	DiskName                  *string                `mapstructure:"disk_name" required:"false" cty:"disk_name" hcl:"disk_name"`
+	DiskReadOnly              *bool                  `mapstructure:"disk_read_only" required:"false" cty:"disk_read_only" hcl:"disk_read_only"`
+	DiskSectorSize            *string                `mapstructure:"disk_sector_size" required:"false" cty:"disk_sector_size" hcl:"disk_sector_size"`
+	DiskSize                  *string                `mapstructure:"disk_size" required:"false" cty:"disk_size" hcl:"disk_size"`
+	DiskSourceSnapshot        *string                `mapstructure:"disk_source_snapshot" required:"false" cty:"disk_source_snapshot" hcl:"disk_source_snapshot"`
+	DiskUseZVOL               *bool                  `mapstructure:"disk_use_zvol" required:"false" cty:"disk_use_zvol" hcl:"disk_use_zvol"`
+	DiskZPool                 *string                `mapstructure:"disk_zpool" required:"false" cty:"disk_zpool" hcl:"disk_zpool"`
+	EFIVars                   *string                `mapstructure:"efi_vars" required:"false" cty:"efi_vars" hcl:"efi_vars"`
+	Firmware                  *string                `mapstructure:"firmware" required:"false" cty:"firmware" hcl:"firmware"`
+	HostNIC                   *string                `mapstructure:"host_nic" cty:"host_nic" hcl:"host_nic"`
+	MACAddress                *string                `mapstructure:"mac_address" required:"false" cty:"mac_address" hcl:"mac_address"`
+	MaxReboots                *int                   `mapstructure:"max_reboots" required:"false" cty:"max_reboots" hcl:"max_reboots"`
+	MemorySize                *string                `mapstructure:"memory" required:"false" cty:"memory" hcl:"memory"`
+	MemoryWired               *bool                  `mapstructure:"memory_wired" required:"false" cty:"memory_wired" hcl:"memory_wired"`
+	NetDevice                 *string                `mapstructure:"net_device" required:"false" cty:"net_device" hcl:"net_device"`
+	OutputCompression         *string                `mapstructure:"output_compression" required:"false" cty:"output_compression" hcl:"output_compression"`
+	OutputDir                 *string                `mapstructure:"output_directory" required:"false" cty:"output_directory" hcl:"output_directory"`
+	OutputFormat              []string               `mapstructure:"output_format" required:"false" cty:"output_format" hcl:"output_format"`
+	PublishDataset            *string                `mapstructure:"publish_dataset" required:"false" cty:"publish_dataset" hcl:"publish_dataset"`
+	SerialLog                 *bool                  `mapstructure:"serial_log" required:"false" cty:"serial_log" hcl:"serial_log"`
+	SMBIOSSerial              *string                `mapstructure:"smbios_serial" required:"false" cty:"smbios_serial" hcl:"smbios_serial"`
+	TritonImage               *FlatTritonImageConfig `mapstructure:"triton_image" required:"false" cty:"triton_image" hcl:"triton_image"`
+	VMName                    *string                `mapstructure:"vm_name" required:"false" cty:"vm_name" hcl:"vm_name"`
+	VMUUID                    *string                `mapstructure:"vm_uuid" required:"false" cty:"vm_uuid" hcl:"vm_uuid"`
+	VNCBindAddress            *string                `mapstructure:"vnc_bind_address" required:"false" cty:"vnc_bind_address" hcl:"vnc_bind_address"`
+	VNCPortMax                *int                   `mapstructure:"vnc_port_max" cty:"vnc_port_max" hcl:"vnc_port_max"`
+	VNCPortMin                *int                   `mapstructure:"vnc_port_min" required:"false" cty:"vnc_port_min" hcl:"vnc_port_min"`
+	VNCUsePassword            *bool                  `mapstructure:"vnc_use_password" required:"false" cty:"vnc_use_password" hcl:"vnc_use_password"`
+	VNICCreate                *bool                  `mapstructure:"vnic_create" required:"false" cty:"vnic_create" hcl:"vnic_create"`
+	VNICName                  *string                `mapstructure:"vnic_name" required:"false" cty:"vnic_name" hcl:"vnic_name"`
+	VNICLink                  *string                `mapstructure:"vnic_link" required:"false" cty:"vnic_link" hcl:"vnic_link"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -157,7 +205,35 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"sockets":                      &hcldec.AttrSpec{Name: "sockets", Type: cty.Number, Required: false},
 		"cores":                        &hcldec.AttrSpec{Name: "cores", Type: cty.Number, Required: false},
 		"threads":                      &hcldec.AttrSpec{Name: "threads", Type: cty.Number, Required: false},
+		"cpu_pinning":                  &hcldec.AttrSpec{Name: "cpu_pinning", Type: cty.Map(cty.String), Required: false},
+		"guest_os_type":                &hcldec.AttrSpec{Name: "guest_os_type", Type: cty.String, Required: false},
+		"acpi":                         &hcldec.AttrSpec{Name: "acpi", Type: cty.Bool, Required: false},
+		"disable_mptable":              &hcldec.AttrSpec{Name: "disable_mptable", Type: cty.Bool, Required: false},
+		"ignore_unimplemented_msr":     &hcldec.AttrSpec{Name: "ignore_unimplemented_msr", Type: cty.Bool, Required: false},
+		"rtc_utc":                      &hcldec.AttrSpec{Name: "rtc_utc", Type: cty.Bool, Required: false},
+		"x2apic":                       &hcldec.AttrSpec{Name: "x2apic", Type: cty.Bool, Required: false},
+		"zfs_send_base_snapshot":       &hcldec.AttrSpec{Name: "zfs_send_base_snapshot", Type: cty.String, Required: false},
+		"zfs_send_compressed":          &hcldec.AttrSpec{Name: "zfs_send_compressed", Type: cty.Bool, Required: false},
+		"zfs_send_embedded":            &hcldec.AttrSpec{Name: "zfs_send_embedded", Type: cty.Bool, Required: false},
+		"zfs_send_intermediates":       &hcldec.AttrSpec{Name: "zfs_send_intermediates", Type: cty.Bool, Required: false},
+		"zfs_send_large_blocks":        &hcldec.AttrSpec{Name: "zfs_send_large_blocks", Type: cty.Bool, Required: false},
+		"zfs_send_raw":                 &hcldec.AttrSpec{Name: "zfs_send_raw", Type: cty.Bool, Required: false},
+		"disk_zvol_compression":        &hcldec.AttrSpec{Name: "disk_zvol_compression", Type: cty.String, Required: false},
+		"disk_zvol_properties":         &hcldec.AttrSpec{Name: "disk_zvol_properties", Type: cty.Map(cty.String), Required: false},
+		"disk_zvol_sparse":             &hcldec.AttrSpec{Name: "disk_zvol_sparse", Type: cty.Bool, Required: false},
+		"disk_zvol_volblocksize":       &hcldec.AttrSpec{Name: "disk_zvol_volblocksize", Type: cty.String, Required: false},
+		"disk_additional_size":         &hcldec.AttrSpec{Name: "disk_additional_size", Type: cty.List(cty.String), Required: false},
+		"disk_additional_cache":        &hcldec.AttrSpec{Name: "disk_additional_cache", Type: cty.String, Required: false},
+		"disk_additional_read_only":    &hcldec.AttrSpec{Name: "disk_additional_read_only", Type: cty.Bool, Required: false},
+		"disk_additional_sector_size":  &hcldec.AttrSpec{Name: "disk_additional_sector_size", Type: cty.String, Required: false},
+		"additional_nics":              &hcldec.BlockListSpec{TypeName: "additional_nics", Nested: hcldec.ObjectSpec((*FlatNICConfig)(nil).HCL2Spec())},
+		"bhyve_args":                   &hcldec.AttrSpec{Name: "bhyve_args", Type: cty.List(cty.List(cty.String)), Required: false},
+		"boot_detach_iso_after":        &hcldec.AttrSpec{Name: "boot_detach_iso_after", Type: cty.Number, Required: false},
 		"boot_steps":                   &hcldec.AttrSpec{Name: "boot_steps", Type: cty.List(cty.List(cty.String)), Required: false},
+		"boot_command_transport":       &hcldec.AttrSpec{Name: "boot_command_transport", Type: cty.String, Required: false},
+		"cloud_init_meta_data":         &hcldec.AttrSpec{Name: "cloud_init_meta_data", Type: cty.String, Required: false},
+		"cloud_init_network_config":    &hcldec.AttrSpec{Name: "cloud_init_network_config", Type: cty.String, Required: false},
+		"cloud_init_user_data":         &hcldec.AttrSpec{Name: "cloud_init_user_data", Type: cty.String, Required: false},
 		"communicator":                 &hcldec.AttrSpec{Name: "communicator", Type: cty.String, Required: false},
 		"pause_before_connecting":      &hcldec.AttrSpec{Name: "pause_before_connecting", Type: cty.String, Required: false},
 		"ssh_host":                     &hcldec.AttrSpec{Name: "ssh_host", Type: cty.String, Required: false},
@@ -209,14 +285,34 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"winrm_use_ntlm":               &hcldec.AttrSpec{Name: "winrm_use_ntlm", Type: cty.Bool, Required: false},
 		"host_port_min":                &hcldec.AttrSpec{Name: "host_port_min", Type: cty.Number, Required: false},
 		"host_port_max":                &hcldec.AttrSpec{Name: "host_port_max", Type: cty.Number, Required: false},
+		"disk_cache":                   &hcldec.AttrSpec{Name: "disk_cache", Type: cty.String, Required: false},
+		"disk_compression":             &hcldec.AttrSpec{Name: "disk_compression", Type: cty.Bool, Required: false},
+		"disk_image":                   &hcldec.AttrSpec{Name: "disk_image", Type: cty.Bool, Required: false},
+		"disk_interface":               &hcldec.AttrSpec{Name: "disk_interface", Type: cty.String, Required: false},
 		"disk_name":                    &hcldec.AttrSpec{Name: "disk_name", Type: cty.String, Required: false},
+		"disk_read_only":               &hcldec.AttrSpec{Name: "disk_read_only", Type: cty.Bool, Required: false},
+		"disk_sector_size":             &hcldec.AttrSpec{Name: "disk_sector_size", Type: cty.String, Required: false},
 		"disk_size":                    &hcldec.AttrSpec{Name: "disk_size", Type: cty.String, Required: false},
+		"disk_source_snapshot":         &hcldec.AttrSpec{Name: "disk_source_snapshot", Type: cty.String, Required: false},
 		"disk_use_zvol":                &hcldec.AttrSpec{Name: "disk_use_zvol", Type: cty.Bool, Required: false},
 		"disk_zpool":                   &hcldec.AttrSpec{Name: "disk_zpool", Type: cty.String, Required: false},
+		"efi_vars":                     &hcldec.AttrSpec{Name: "efi_vars", Type: cty.String, Required: false},
+		"firmware":                     &hcldec.AttrSpec{Name: "firmware", Type: cty.String, Required: false},
 		"host_nic":                     &hcldec.AttrSpec{Name: "host_nic", Type: cty.String, Required: false},
-		"memory":                       &hcldec.AttrSpec{Name: "memory", Type: cty.Number, Required: false},
+		"mac_address":                  &hcldec.AttrSpec{Name: "mac_address", Type: cty.String, Required: false},
+		"max_reboots":                  &hcldec.AttrSpec{Name: "max_reboots", Type: cty.Number, Required: false},
+		"memory":                       &hcldec.AttrSpec{Name: "memory", Type: cty.String, Required: false},
+		"memory_wired":                 &hcldec.AttrSpec{Name: "memory_wired", Type: cty.Bool, Required: false},
+		"net_device":                   &hcldec.AttrSpec{Name: "net_device", Type: cty.String, Required: false},
+		"output_compression":           &hcldec.AttrSpec{Name: "output_compression", Type: cty.String, Required: false},
 		"output_directory":             &hcldec.AttrSpec{Name: "output_directory", Type: cty.String, Required: false},
+		"output_format":                &hcldec.AttrSpec{Name: "output_format", Type: cty.List(cty.String), Required: false},
+		"publish_dataset":              &hcldec.AttrSpec{Name: "publish_dataset", Type: cty.String, Required: false},
+		"serial_log":                   &hcldec.AttrSpec{Name: "serial_log", Type: cty.Bool, Required: false},
+		"smbios_serial":                &hcldec.AttrSpec{Name: "smbios_serial", Type: cty.String, Required: false},
+		"triton_image":                 &hcldec.BlockSpec{TypeName: "triton_image", Nested: hcldec.ObjectSpec((*FlatTritonImageConfig)(nil).HCL2Spec())},
 		"vm_name":                      &hcldec.AttrSpec{Name: "vm_name", Type: cty.String, Required: false},
+		"vm_uuid":                      &hcldec.AttrSpec{Name: "vm_uuid", Type: cty.String, Required: false},
 		"vnc_bind_address":             &hcldec.AttrSpec{Name: "vnc_bind_address", Type: cty.String, Required: false},
 		"vnc_port_max":                 &hcldec.AttrSpec{Name: "vnc_port_max", Type: cty.Number, Required: false},
 		"vnc_port_min":                 &hcldec.AttrSpec{Name: "vnc_port_min", Type: cty.Number, Required: false},
@@ -227,3 +323,141 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 	}
 	return s
 }
+
+// FlatNICConfig is an auto-generated flat version of NICConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatNICConfig struct {
+	Link       *string `mapstructure:"link" required:"false" cty:"link" hcl:"link"`
+	MACAddress *string `mapstructure:"mac_address" required:"false" cty:"mac_address" hcl:"mac_address"`
+	Name       *string `mapstructure:"name" required:"false" cty:"name" hcl:"name"`
+	NetDevice  *string `mapstructure:"net_device" required:"false" cty:"net_device" hcl:"net_device"`
+}
+
+// FlatMapstructure returns a new FlatNICConfig.
+// FlatNICConfig is an auto-generated flat version of NICConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*NICConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatNICConfig)
+}
+
+// HCL2Spec returns the hcl spec of a NICConfig.
+// This spec is used by HCL to read the fields of NICConfig.
+// The decoded values from this spec will then be applied to a FlatNICConfig.
+func (*FlatNICConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"link":        &hcldec.AttrSpec{Name: "link", Type: cty.String, Required: false},
+		"mac_address": &hcldec.AttrSpec{Name: "mac_address", Type: cty.String, Required: false},
+		"name":        &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"net_device":  &hcldec.AttrSpec{Name: "net_device", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatTritonImageConfig is an auto-generated flat version of TritonImageConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatTritonImageConfig struct {
+	CPUType      *string                       `mapstructure:"cpu_type" required:"false" cty:"cpu_type" hcl:"cpu_type"`
+	Description  *string                       `mapstructure:"description" required:"false" cty:"description" hcl:"description"`
+	DiskDriver   *string                       `mapstructure:"disk_driver" required:"false" cty:"disk_driver" hcl:"disk_driver"`
+	Homepage     *string                       `mapstructure:"homepage" required:"false" cty:"homepage" hcl:"homepage"`
+	ImageSize    *int                          `mapstructure:"image_size" required:"false" cty:"image_size" hcl:"image_size"`
+	Name         *string                       `mapstructure:"name" required:"false" cty:"name" hcl:"name"`
+	NICDriver    *string                       `mapstructure:"nic_driver" required:"false" cty:"nic_driver" hcl:"nic_driver"`
+	Origin       *string                       `mapstructure:"origin" required:"false" cty:"origin" hcl:"origin"`
+	OS           *string                       `mapstructure:"os" required:"false" cty:"os" hcl:"os"`
+	Owner        *string                       `mapstructure:"owner" required:"false" cty:"owner" hcl:"owner"`
+	Public       *bool                         `mapstructure:"public" required:"false" cty:"public" hcl:"public"`
+	Requirements *FlatTritonRequirementsConfig `mapstructure:"requirements" required:"false" cty:"requirements" hcl:"requirements"`
+	Tags         map[string]string             `mapstructure:"tags" required:"false" cty:"tags" hcl:"tags"`
+	UUID         *string                       `mapstructure:"uuid" required:"false" cty:"uuid" hcl:"uuid"`
+	Version      *string                       `mapstructure:"version" required:"false" cty:"version" hcl:"version"`
+}
+
+// FlatMapstructure returns a new FlatTritonImageConfig.
+// FlatTritonImageConfig is an auto-generated flat version of TritonImageConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*TritonImageConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatTritonImageConfig)
+}
+
+// HCL2Spec returns the hcl spec of a TritonImageConfig.
+// This spec is used by HCL to read the fields of TritonImageConfig.
+// The decoded values from this spec will then be applied to a FlatTritonImageConfig.
+func (*FlatTritonImageConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"cpu_type":     &hcldec.AttrSpec{Name: "cpu_type", Type: cty.String, Required: false},
+		"description":  &hcldec.AttrSpec{Name: "description", Type: cty.String, Required: false},
+		"disk_driver":  &hcldec.AttrSpec{Name: "disk_driver", Type: cty.String, Required: false},
+		"homepage":     &hcldec.AttrSpec{Name: "homepage", Type: cty.String, Required: false},
+		"image_size":   &hcldec.AttrSpec{Name: "image_size", Type: cty.Number, Required: false},
+		"name":         &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"nic_driver":   &hcldec.AttrSpec{Name: "nic_driver", Type: cty.String, Required: false},
+		"origin":       &hcldec.AttrSpec{Name: "origin", Type: cty.String, Required: false},
+		"os":           &hcldec.AttrSpec{Name: "os", Type: cty.String, Required: false},
+		"owner":        &hcldec.AttrSpec{Name: "owner", Type: cty.String, Required: false},
+		"public":       &hcldec.AttrSpec{Name: "public", Type: cty.Bool, Required: false},
+		"requirements": &hcldec.BlockSpec{TypeName: "requirements", Nested: hcldec.ObjectSpec((*FlatTritonRequirementsConfig)(nil).HCL2Spec())},
+		"tags":         &hcldec.AttrSpec{Name: "tags", Type: cty.Map(cty.String), Required: false},
+		"uuid":         &hcldec.AttrSpec{Name: "uuid", Type: cty.String, Required: false},
+		"version":      &hcldec.AttrSpec{Name: "version", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatTritonNetworkConfig is an auto-generated flat version of TritonNetworkConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatTritonNetworkConfig struct {
+	Description *string `mapstructure:"description" required:"false" cty:"description" hcl:"description"`
+	Name        *string `mapstructure:"name" required:"false" cty:"name" hcl:"name"`
+}
+
+// FlatMapstructure returns a new FlatTritonNetworkConfig.
+// FlatTritonNetworkConfig is an auto-generated flat version of TritonNetworkConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*TritonNetworkConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatTritonNetworkConfig)
+}
+
+// HCL2Spec returns the hcl spec of a TritonNetworkConfig.
+// This spec is used by HCL to read the fields of TritonNetworkConfig.
+// The decoded values from this spec will then be applied to a FlatTritonNetworkConfig.
+func (*FlatTritonNetworkConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"description": &hcldec.AttrSpec{Name: "description", Type: cty.String, Required: false},
+		"name":        &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatTritonRequirementsConfig is an auto-generated flat version of TritonRequirementsConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatTritonRequirementsConfig struct {
+	Bootrom  *string                   `mapstructure:"bootrom" required:"false" cty:"bootrom" hcl:"bootrom"`
+	Brand    *string                   `mapstructure:"brand" required:"false" cty:"brand" hcl:"brand"`
+	MaxRAM   *int                      `mapstructure:"max_ram" required:"false" cty:"max_ram" hcl:"max_ram"`
+	MinRAM   *int                      `mapstructure:"min_ram" required:"false" cty:"min_ram" hcl:"min_ram"`
+	Networks []FlatTritonNetworkConfig `mapstructure:"networks" required:"false" cty:"networks" hcl:"networks"`
+	SSHKey   *bool                     `mapstructure:"ssh_key" required:"false" cty:"ssh_key" hcl:"ssh_key"`
+}
+
+// FlatMapstructure returns a new FlatTritonRequirementsConfig.
+// FlatTritonRequirementsConfig is an auto-generated flat version of TritonRequirementsConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*TritonRequirementsConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatTritonRequirementsConfig)
+}
+
+// HCL2Spec returns the hcl spec of a TritonRequirementsConfig.
+// This spec is used by HCL to read the fields of TritonRequirementsConfig.
+// The decoded values from this spec will then be applied to a FlatTritonRequirementsConfig.
+func (*FlatTritonRequirementsConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"bootrom":  &hcldec.AttrSpec{Name: "bootrom", Type: cty.String, Required: false},
+		"brand":    &hcldec.AttrSpec{Name: "brand", Type: cty.String, Required: false},
+		"max_ram":  &hcldec.AttrSpec{Name: "max_ram", Type: cty.Number, Required: false},
+		"min_ram":  &hcldec.AttrSpec{Name: "min_ram", Type: cty.Number, Required: false},
+		"networks": &hcldec.BlockListSpec{TypeName: "networks", Nested: hcldec.ObjectSpec((*FlatTritonNetworkConfig)(nil).HCL2Spec())},
+		"ssh_key":  &hcldec.AttrSpec{Name: "ssh_key", Type: cty.Bool, Required: false},
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+package bhyve
+
+import (
+	"testing"
+)
+
+func TestParseMemorySize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int
+		err  bool
+	}{
+		{"1024", 1024, false},
+		{"512M", 512, false},
+		{"512m", 512, false},
+		{"4G", 4096, false},
+		{" 2g ", 2048, false},
+		{"1T", 1024 * 1024, false},
+		{"20480K", 20, false},
+		{"10", 10, false},
+		{"4GB", 4096, false},
+		{"512MB", 512, false},
+		{"0", 0, true},
+		{"9", 0, true},
+		{"512K", 0, true},
+		{"1536K", 0, true},
+		{"4096T", 0, true},
+		{"", 0, true},
+		{"G", 0, true},
+		{"1.5G", 0, true},
+		{"-1", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMemorySize(tt.size)
+		if (err != nil) != tt.err {
+			t.Errorf("parseMemorySize(%q) error = %v, want error %v", tt.size, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseMemorySize(%q) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestValidateDiskSectorSize(t *testing.T) {
+	tests := []struct {
+		iface       string
+		sector_size string
+		err         bool
+	}{
+		{"virtio-blk", "512", false},
+		{"virtio-blk", "4096", false},
+		{"virtio-blk", "512/4096", false},
+		{"ahci-hd", "4096/4096", false},
+		{"virtio-blk", "", true},
+		{"virtio-blk", "256", true},
+		{"virtio-blk", "1000", true},
+		{"virtio-blk", "512/abc", true},
+		{"virtio-blk", "512/4096/8192", true},
+		{"nvme", "512", false},
+		{"nvme", "4096", false},
+		{"nvme", "8192", false},
+		{"nvme", "1024", true},
+		{"nvme", "16384", true},
+		{"nvme", "512/4096", true},
+	}
+
+	for _, tt := range tests {
+		c := &Config{}
+		c.DiskInterface = tt.iface
+
+		errs := c.validateDiskSectorSize("disk_sector_size", tt.sector_size)
+		if (len(errs) > 0) != tt.err {
+			t.Errorf("%s %q: errors = %v, want error %v", tt.iface, tt.sector_size, errs, tt.err)
+		}
+	}
+}
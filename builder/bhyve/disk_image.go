@@ -0,0 +1,223 @@
+package bhyve
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// The size of the blocks that copySparse checks for zeros.
+const diskImageBlockSize = 64 * 1024
+
+// Limits on the size of the tables and clusters that are allocated while
+// reading a disk image, so that a corrupt image cannot exhaust memory.  They
+// are far larger than any real image needs.
+const (
+	diskImageMaxTable   = 256 * 1024 * 1024
+	diskImageMaxCluster = 32 * 1024 * 1024
+)
+
+// checkImageTable checks that a table of count entries of entrySize bytes at
+// offset lies within a file of fileSize bytes, and is small enough to be
+// read into memory.
+func checkImageTable(offset int64, count int64, entrySize int64, fileSize int64) error {
+	if offset < 0 || count < 0 || count > diskImageMaxTable/entrySize {
+		return fmt.Errorf("table of %d entries at offset %d is too large", count, offset)
+	}
+	if offset > fileSize-count*entrySize {
+		return fmt.Errorf("table of %d entries at offset %d extends past the end of the file",
+			count, offset)
+	}
+	return nil
+}
+
+// parseDiskSize parses a disk size in bytes, or with a K, M, G or T suffix
+// as accepted by both zfs and mkfile, and returns it in bytes.
+func parseDiskSize(size string) (int64, error) {
+	size = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B")
+
+	shift := 0
+	suffixes := map[byte]int{'K': 10, 'M': 20, 'G': 30, 'T': 40}
+	if n := len(size); n > 0 {
+		if s, ok := suffixes[size[n-1]]; ok {
+			shift = s
+			size = size[:n-1]
+		}
+	}
+
+	v, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("disk size must be in bytes, or have a K, M, G or T suffix")
+	}
+
+	return v << shift, nil
+}
+
+// writeDiskImage writes the disk image at imagePath over the start of the
+// disk at diskPath, which must already exist and be at least as large as
+// the image.  qcow2, VMDK and VHD images are converted as they are read,
+// anything else is taken to be raw.  Blocks of zeros are skipped so that the
+// disk stays sparse.
+func writeDiskImage(ui packer.Ui, imagePath string, diskPath string, diskSize int64) error {
+	src, err := os.Open(imagePath)
+	if err != nil {
+		return fmt.Errorf("Error opening disk image: %s", err)
+	}
+	defer src.Close()
+
+	image, size, format, err := openDiskImage(src)
+	if err != nil {
+		return fmt.Errorf("Error opening disk image: %s", err)
+	}
+	if size > diskSize {
+		return fmt.Errorf("disk_size %d is smaller than the disk image (%d bytes)",
+			diskSize, size)
+	}
+
+	dst, err := os.OpenFile(diskPath, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("Error opening disk: %s", err)
+	}
+	defer dst.Close()
+
+	ui.Say(fmt.Sprintf("Writing %s disk image %s to %s", format, imagePath, diskPath))
+
+	body := ui.TrackProgress(filepath.Base(imagePath), 0, size, io.NopCloser(image))
+	defer body.Close()
+
+	if err := copySparse(dst, body, io.Discard); err != nil {
+		return fmt.Errorf("Error writing disk image: %s", err)
+	}
+
+	return dst.Close()
+}
+
+// openDiskImage detects the format of a disk image from its magic bytes,
+// and returns a reader for the virtual disk contents along with their size
+// and the name of the format.
+func openDiskImage(f *os.File) (io.Reader, int64, string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	magic := make([]byte, 24)
+	if _, err := f.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return nil, 0, "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, qcow2Magic):
+		r, size, err := openQcow2(f)
+		return r, size, "qcow2", err
+	case bytes.HasPrefix(magic, vmdkMagic):
+		r, size, err := openVMDK(f)
+		return r, size, "VMDK", err
+	case bytes.HasPrefix(magic, []byte("# Disk DescriptorFile")):
+		return nil, 0, "", fmt.Errorf("VMDK descriptor files are not supported, use a monolithic VMDK")
+	case bytes.HasPrefix(magic, vhdCookie):
+		r, size, err := openVHD(f, info.Size())
+		return r, size, "VHD", err
+	}
+
+	// Fixed VHDs only have a footer.
+	if info.Size() >= vhdFooterSize {
+		footer := make([]byte, len(vhdCookie))
+		if _, err := f.ReadAt(footer, info.Size()-vhdFooterSize); err != nil {
+			return nil, 0, "", err
+		}
+		if bytes.Equal(footer, vhdCookie) {
+			r, size, err := openVHD(f, info.Size())
+			return r, size, "VHD", err
+		}
+	}
+
+	return f, info.Size(), "raw", nil
+}
+
+// clusterReader presents a disk image made up of fixed size clusters as a
+// stream of its virtual contents.  read fills buf with the contents of the
+// cluster at index, which is all zeros if it is not allocated.
+type clusterReader struct {
+	size        int64
+	clusterSize int64
+	read        func(index int64, buf []byte) error
+
+	offset int64
+	buf    []byte
+	index  int64
+}
+
+func newClusterReader(size int64, clusterSize int64, read func(int64, []byte) error) *clusterReader {
+	return &clusterReader{
+		size:        size,
+		clusterSize: clusterSize,
+		read:        read,
+		index:       -1,
+	}
+}
+
+func (r *clusterReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	index := r.offset / r.clusterSize
+	if index != r.index {
+		if r.buf == nil {
+			r.buf = make([]byte, r.clusterSize)
+		}
+		for i := range r.buf {
+			r.buf[i] = 0
+		}
+		if err := r.read(index, r.buf); err != nil {
+			return 0, err
+		}
+		r.index = index
+	}
+
+	start := r.offset % r.clusterSize
+	end := r.clusterSize
+	if remaining := r.size - index*r.clusterSize; remaining < end {
+		end = remaining
+	}
+
+	n := copy(p, r.buf[start:end])
+	r.offset += int64(n)
+	return n, nil
+}
+
+// copySparse copies src to the start of dst, skipping blocks of zeros so
+// that they are left as holes.  Everything read is also written to w, so
+// that it can be digested.
+func copySparse(dst *os.File, src io.Reader, w io.Writer) error {
+	buf := make([]byte, diskImageBlockSize)
+	zero := make([]byte, diskImageBlockSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			if !bytes.Equal(buf[:n], zero[:n]) {
+				if _, err := dst.WriteAt(buf[:n], offset); err != nil {
+					return err
+				}
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
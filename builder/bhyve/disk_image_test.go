@@ -0,0 +1,93 @@
+package bhyve
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDiskSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+		err  bool
+	}{
+		{"1048576", 1024 * 1024, false},
+		{"16K", 16 * 1024, false},
+		{"512M", 512 * 1024 * 1024, false},
+		{"40G", 40 << 30, false},
+		{"40g", 40 << 30, false},
+		{"40GB", 40 << 30, false},
+		{"2T", 2 << 40, false},
+		{" 8G ", 8 << 30, false},
+		{"", 0, true},
+		{"G", 0, true},
+		{"1.5G", 0, true},
+		{"10X", 0, true},
+		{"-1G", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDiskSize(tt.size)
+		if (err != nil) != tt.err {
+			t.Errorf("parseDiskSize(%q) error = %v, want error %v", tt.size, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseDiskSize(%q) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+// openCorruptImage writes image to a file with value written big or little
+// endian at offset, and returns the error from opening it.
+func openCorruptImage(t *testing.T, image []byte, offset int64, value uint64, size int, order binary.ByteOrder) error {
+	corrupt := append([]byte{}, image...)
+	switch size {
+	case 4:
+		order.PutUint32(corrupt[offset:], uint32(value))
+	case 8:
+		order.PutUint64(corrupt[offset:], value)
+	}
+
+	path := filepath.Join(t.TempDir(), "corrupt")
+	if err := os.WriteFile(path, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, _, _, err = openDiskImage(f)
+	return err
+}
+
+func TestCheckImageTable(t *testing.T) {
+	tests := []struct {
+		offset    int64
+		count     int64
+		entrySize int64
+		err       bool
+	}{
+		{512, 16, 8, false},
+		{512, 64, 8, false},
+		{1024, 0, 4, false},
+		{512, 65, 8, true},
+		{-1, 1, 4, true},
+		{0, -1, 4, true},
+		{math.MaxInt64, 1, 4, true},
+		{0, diskImageMaxTable/4 + 1, 4, true},
+	}
+
+	for _, tt := range tests {
+		err := checkImageTable(tt.offset, tt.count, tt.entrySize, 1024)
+		if (err != nil) != tt.err {
+			t.Errorf("checkImageTable(%d, %d, %d) error = %v, want error %v",
+				tt.offset, tt.count, tt.entrySize, err, tt.err)
+		}
+	}
+}
@@ -2,30 +2,160 @@ package bhyve
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
 )
 
+// Names of the bhyve configuration files, with and without the install media
+// attached.  bhyve is started from private copies, as they hold the VNC
+// password, and copies with it redacted are kept in the output directory.
+const (
+	vmConfigBootName = "bhyve-boot.conf"
+	vmConfigName     = "bhyve.conf"
+)
+
+// Use the same slots as pci_slot_t in
+// illumos-joyent usr/src/lib/brand/bhyve/zone/boot.c
+const (
+	SlotHostBridge int = 0
+	SlotCDROM          = 3
+	SlotBootDisk       = 4
+	SlotNIC            = 6
+	SlotCDROM2         = 7 // Additional CD generated by CDFiles
+	SlotFBuf           = 30
+	SlotLPC            = 31
+	SlotMax            = 31
+)
+
+// usedPCISlots returns the fixed slots above that this build puts a device
+// in, and the name of each device.  The CD-ROMs and framebuffer are only
+// attached when there is something to use them for.
+func (c *Config) usedPCISlots() map[int]string {
+	used := map[int]string{
+		SlotHostBridge: "hostbridge",
+		SlotBootDisk:   "boot disk",
+		SlotNIC:        "NIC",
+		SlotLPC:        "LPC",
+	}
+	if !c.DiskImage {
+		used[SlotCDROM] = "CD-ROM"
+	}
+	if len(c.CDFiles) > 0 || len(c.CDContent) > 0 || c.useCloudInit() {
+		used[SlotCDROM2] = "cd_files CD-ROM"
+	}
+	if !c.VNCConfig.DisableVNC {
+		used[SlotFBuf] = "framebuffer"
+	}
+
+	return used
+}
+
+// freePCISlots returns the slots not used by any of the fixed devices above
+// or by bhyve_args, in the order that they are handed out to additional
+// devices.  Only slots after the boot disk are used so that guests enumerate
+// it first.
+func (c *Config) freePCISlots() []int {
+	used := c.usedPCISlots()
+	args := c.bhyveArgsSlots()
+
+	slots := []int{}
+	for slot := SlotBootDisk + 1; slot <= SlotMax; slot++ {
+		if _, ok := used[slot]; !ok && !args[slot] {
+			slots = append(slots, slot)
+		}
+	}
+
+	return slots
+}
+
+// exitReason is why bhyve exited, as given by its exit status.
+type exitReason int
+
+const (
+	exitRebooted exitReason = iota
+	exitPoweroff
+	exitHalt
+	exitTripleFault
+	exitError
+)
+
+func (r exitReason) String() string {
+	switch r {
+	case exitRebooted:
+		return "rebooted"
+	case exitPoweroff:
+		return "powered off"
+	case exitHalt:
+		return "halted"
+	case exitTripleFault:
+		return "triple fault"
+	default:
+		return "error"
+	}
+}
+
+// exitReasonFromError maps the result of waiting for bhyve to an
+// exitReason.  Anything other than a known exit status, such as being
+// killed by a signal, is an error.
+func exitReasonFromError(err error) exitReason {
+	if err == nil {
+		return exitRebooted
+	}
+
+	if status, ok := err.(*exec.ExitError); ok {
+		switch status.ExitCode() {
+		case 1:
+			return exitPoweroff
+		case 2:
+			return exitHalt
+		case 3:
+			return exitTripleFault
+		}
+	}
+
+	return exitError
+}
+
 type Driver interface {
 	Start() error
-	Stop() error
+	Stop(context.Context) error
 	WaitForShutdown(<-chan struct{}) bool
+	// ExitError returns the error the VM exited with, if any.
+	ExitError() error
 }
 
 type BhyveDriver struct {
-	config  *Config
-	state   multistep.StateBag
-	vmCmd   *exec.Cmd
-	vmRetCh <-chan int
-	vmErrCh <-chan error
-	lock    sync.Mutex
+	config   *Config
+	state    multistep.StateBag
+	vmCmd    *exec.Cmd
+	vmRetCh  <-chan int
+	vmErrCh  <-chan error
+	vmErr    error
+	vmDoneCh <-chan struct{}
+	lock     sync.Mutex
+
+	// The currently running bhyve process, which changes each time the
+	// guest reboots, and whether Stop() has been called.  These have
+	// their own lock as the VM goroutine needs them while Start() holds
+	// the main one.
+	proc     *os.Process
+	stopping bool
+	procLock sync.Mutex
 }
 
 func (d *BhyveDriver) Start() error {
@@ -36,62 +166,70 @@ func (d *BhyveDriver) Start() error {
 		panic("Existing VM state found")
 	}
 
-	// Use the same slots as pci_slot_t in
-	// illumos-joyent usr/src/lib/brand/bhyve/zone/boot.c
-	const (
-		SlotHostBridge int = 0
-		SlotCDROM          = 3
-		SlotBootDisk       = 4
-		SlotNIC            = 6
-		SlotCDROM2         = 7 // Additional CD generated by CDFiles
-		SlotFBuf           = 30
-		SlotLPC            = 31
-	)
-
-	common_args := []string{
-		"-D",
-		"-H",
-		"-c", d.config.CPUConfig.cmdline(),
-		"-l", "bootrom,/usr/share/bhyve/uefi-rom.bin",
-		"-m", strconv.Itoa(d.config.MemorySize),
-		"-s", fmt.Sprintf("%d,hostbridge,model=i440fx", SlotHostBridge),
-		"-s", fmt.Sprintf("%d,virtio-blk,%s",
-			SlotBootDisk, d.state.Get("bhyve_disk_path").(string)),
-		"-s", fmt.Sprintf("%d,virtio-net-viona,vnic=%s",
-			SlotNIC, d.config.VNICName),
-		"-s", fmt.Sprintf("%d:0,fbuf,vga=off,rfb=%s:%d,password=%s",
-			SlotFBuf, d.config.VNCBindAddress,
-			d.state.Get("vnc_port").(int),
-			d.state.Get("vnc_password").(string)),
-		"-s", fmt.Sprintf("%d:1,xhci,tablet", SlotFBuf),
-		"-s", fmt.Sprintf("%d,lpc", SlotLPC),
+	// Write two configuration files, one for the initial install with the
+	// boot CDROM attached, and one without, to ensure that we boot from
+	// disk for the post-install steps.  The latter describes the final
+	// guest hardware and is kept with the artifact.
+	vm, err := d.buildVMConfig()
+	if err != nil {
+		return err
 	}
 
-	// cd_path is generated if cd_files is specified, use it for both the
-	// initial boot and post-reboot.
-	extra_cd_path, ok := d.state.Get("cd_path").(string)
-	if ok && extra_cd_path != "" {
-		common_args = append(common_args,
-			"-s", fmt.Sprintf("%d,ahci-cd,%s", SlotCDROM2,
-				extra_cd_path))
-	}
-
-	// Set up two argument lists, one for the initial install with the boot
-	// CDROM attached, and one without, to ensure that we boot from disk
-	// for the post-install steps.
-	boot_args := []string{}
-	boot_args = append(boot_args, common_args...)
-	boot_args = append(boot_args,
-		"-s", fmt.Sprintf("%d,ahci-cd,%s", SlotCDROM,
-			d.state.Get("iso_path").(string)),
-		d.config.VMName,
-	)
+	// User supplied arguments replace the generated settings they overlap
+	// with, anything left over follows the configuration file on the
+	// command line.
+	extra_args := []string{}
+	if len(d.config.BhyveArgs) > 0 {
+		rendered, err := d.renderBhyveArgs()
+		if err != nil {
+			return err
+		}
+		extra_args = applyBhyveArgs(vm, rendered)
+	}
 
-	reboot_args := []string{}
-	reboot_args = append(reboot_args, common_args...)
-	reboot_args = append(reboot_args,
-		d.config.VMName,
-	)
+	boot_vm := vm.clone()
+	if !d.config.DiskImage {
+		boot_vm.addCD(SlotCDROM, d.state.Get("iso_path").(string))
+	}
+
+	for _, conf := range []struct {
+		name string
+		vm   *vmConfig
+	}{{vmConfigBootName, boot_vm}, {vmConfigName, vm}} {
+		path := filepath.Join(d.config.OutputDir, conf.name)
+		redacted := conf.vm.redacted()
+		if err := redacted.write(path); err != nil {
+			return err
+		}
+		log.Printf("Wrote bhyve config %s:\n%s", path, redacted.render())
+	}
+
+	conf_dir, err := os.MkdirTemp("", "packer-bhyve-")
+	if err != nil {
+		return fmt.Errorf("Error creating bhyve config directory: %s", err)
+	}
+	boot_conf := filepath.Join(conf_dir, vmConfigBootName)
+	reboot_conf := filepath.Join(conf_dir, vmConfigName)
+	if err := boot_vm.write(boot_conf); err != nil {
+		os.RemoveAll(conf_dir)
+		return err
+	}
+	if err := vm.write(reboot_conf); err != nil {
+		os.RemoveAll(conf_dir)
+		return err
+	}
+
+	boot_args := []string{"-k", boot_conf}
+	boot_args = append(boot_args, extra_args...)
+	boot_args = append(boot_args, d.config.VMName)
+
+	reboot_args := []string{"-k", reboot_conf}
+	reboot_args = append(reboot_args, extra_args...)
+	reboot_args = append(reboot_args, d.config.VMName)
+
+	console, _ := d.state.Get("serial_console").(*serialConsole)
+
+	ui := d.state.Get("ui").(packer.Ui)
 
 	// bhyve exits when a VM reboots which is a bit annoying in this
 	// context.  We need to check for this and restart it on success so
@@ -99,27 +237,49 @@ func (d *BhyveDriver) Start() error {
 	// the VM is powered off which is a non-zero exit status.
 	retCh := make(chan int, 1)
 	errCh := make(chan error, 1)
+	doneCh := make(chan struct{})
 	var cmd *exec.Cmd
 
+	d.procLock.Lock()
+	d.stopping = false
+	d.procLock.Unlock()
+
 	go func() {
-		var first bool = true
+		defer close(doneCh)
+		defer os.RemoveAll(conf_dir)
+
+		var reboots int = 0
 		var rc int = 0
 		var stderr bytes.Buffer
+		var exitErr error
 
 		for {
-			if first {
-				log.Printf("Starting bhyve VM %s", d.config.VMName)
+			// Keep the install media attached until the guest has
+			// rebooted boot_detach_iso_after times.
+			if reboots < d.config.BootDetachISOAfter {
+				if reboots == 0 {
+					log.Printf("Starting bhyve VM %s", d.config.VMName)
+				} else {
+					log.Printf("Restarting bhyve VM %s after reboot %d", d.config.VMName, reboots)
+				}
 				log.Printf("boot_args %v", boot_args)
 				cmd = exec.Command("/usr/sbin/bhyve", boot_args...)
 			} else {
-				log.Printf("Restarting bhyve VM %s after reboot", d.config.VMName)
+				log.Printf("Restarting bhyve VM %s after reboot %d", d.config.VMName, reboots)
 				log.Printf("reboot_args %v", reboot_args)
 				cmd = exec.Command("/usr/sbin/bhyve", reboot_args...)
 			}
+			stderr.Reset()
 			cmd.Stderr = &stderr
 
+			// Remove any socket left behind by a previous run, bhyve
+			// will create a new one.
+			if console != nil {
+				os.Remove(console.socketPath)
+			}
+
 			if err := cmd.Start(); err != nil {
-				if first {
+				if reboots == 0 {
 					errCh <- fmt.Errorf("Error starting VM: %s", err)
 				} else {
 					errCh <- fmt.Errorf("Error restarting VM: %s", err)
@@ -128,28 +288,71 @@ func (d *BhyveDriver) Start() error {
 				break
 			}
 
-			first = false
-			err := cmd.Wait()
+			d.procLock.Lock()
+			d.proc = cmd.Process
+			stopping := d.stopping
+			d.procLock.Unlock()
 
-			// 0 = rebooted
-			// 1 = powered off
-			// 2 = halted
-			// 3 = triple fault
-			// 4 = exited due to an error
-			if err == nil {
-				continue
-			} else {
-				if status, ok := err.(*exec.ExitError); ok {
-					rc = status.ExitCode()
-					// convert power off or halt to success
-					if rc == 1 || rc == 2 {
-						rc = 0
-					} else {
-						errCh <- fmt.Errorf("%s", strings.TrimSpace(stderr.String()))
+			// Stop() may have been called while we were restarting.
+			if stopping {
+				cmd.Process.Kill()
+			}
+
+			if console != nil {
+				go func() {
+					if err := console.connect(); err != nil {
+						log.Print(err.Error())
 					}
-				}
+				}()
+			}
+
+			reason := exitReasonFromError(cmd.Wait())
+			msg := fmt.Sprintf("bhyve VM %s exited: %s", d.config.VMName, reason)
+			log.Print(msg)
+
+			d.procLock.Lock()
+			d.proc = nil
+			stopping = d.stopping
+			d.procLock.Unlock()
+
+			// However the VM exits while it is being stopped is fine,
+			// and it must not be restarted.
+			if stopping {
+				rc = 0
 				break
 			}
+
+			if reason == exitRebooted {
+				reboots++
+				if d.config.MaxReboots > 0 && reboots > d.config.MaxReboots {
+					err := fmt.Errorf("VM %s rebooted more than max_reboots (%d) times",
+						d.config.VMName, d.config.MaxReboots)
+					ui.Error(err.Error())
+					errCh <- err
+					exitErr = err
+					rc = 1
+					break
+				}
+				ui.Say(fmt.Sprintf("%s, restarting", msg))
+				if reboots == d.config.BootDetachISOAfter {
+					ui.Say("Detaching install media")
+				}
+				continue
+			}
+
+			// Power off or halt is a successful shutdown, anything
+			// else is an error.
+			if reason == exitPoweroff || reason == exitHalt {
+				ui.Say(msg)
+				rc = 0
+			} else {
+				err := fmt.Errorf("%s: %s", msg, strings.TrimSpace(stderr.String()))
+				ui.Error(err.Error())
+				errCh <- err
+				exitErr = err
+				rc = 1
+			}
+			break
 		}
 		retCh <- rc
 
@@ -159,6 +362,8 @@ func (d *BhyveDriver) Start() error {
 		d.vmCmd = nil
 		d.vmRetCh = nil
 		d.vmErrCh = nil
+		d.vmDoneCh = nil
+		d.vmErr = exitErr
 	}()
 
 	// Give bhyve a few seconds to start up to catch errors
@@ -173,20 +378,229 @@ func (d *BhyveDriver) Start() error {
 
 	d.vmRetCh = retCh
 	d.vmErrCh = errCh
+	d.vmDoneCh = doneCh
 	d.vmCmd = cmd
 
 	return nil
 }
 
-func (d *BhyveDriver) Stop() error {
+// buildVMConfig returns the description of the guest hardware, without the
+// install media.
+func (d *BhyveDriver) buildVMConfig() (*vmConfig, error) {
+	vm := newVMConfig(d.config.VMName)
+
+	// The UUID and SMBIOS serial can refer to the HTTP server, for example
+	// to point cloud-init at it.
+	http_ip, _ := d.state.Get("http_ip").(string)
+	http_port, _ := d.state.Get("http_port").(int)
+	ctx := d.config.ctx
+	ctx.Data = &bootCommandTemplateData{
+		http_ip,
+		http_port,
+		d.config.VMName,
+	}
+
+	vm_uuid, err := interpolate.Render(d.config.VMUUID, &ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error interpolating vm_uuid: %s", err)
+	}
+	if _, err := uuid.Parse(vm_uuid); err != nil {
+		return nil, fmt.Errorf("Invalid vm_uuid %q: %s", vm_uuid, err)
+	}
+	vm.set("uuid", vm_uuid)
+	d.state.Put("vm_uuid", vm_uuid)
+
+	if d.config.SMBIOSSerial != "" {
+		serial, err := interpolate.Render(d.config.SMBIOSSerial, &ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Error interpolating smbios_serial: %s", err)
+		}
+		vm.set("smbios.serial", serial)
+	}
+
+	vm.set("destroy_on_poweroff", "true")
+	vm.set("x86.vmexit_on_hlt", "true")
+	d.config.CPUConfig.configure(vm)
+	vm.set("memory.size", fmt.Sprintf("%sM", d.config.MemorySize))
+	if d.config.MemoryWired {
+		vm.set("memory.wired", "true")
+	}
+	d.config.GuestConfig.configure(vm)
+
+	// The EFI variable store is optional, without it any changes the
+	// guest makes to its boot entries are lost when bhyve exits.
+	vm.set("lpc.bootrom", d.config.firmwarePath())
+	if efi_vars_path, ok := d.state.Get("efi_vars_path").(string); ok {
+		vm.set("lpc.bootvars", efi_vars_path)
+	}
+
+	// Attach com1 to a socket if the serial console is in use, so that
+	// its output can be streamed to the console log.
+	if console, ok := d.state.Get("serial_console").(*serialConsole); ok {
+		vm.set("lpc.com1.path", fmt.Sprintf("socket,%s", console.socketPath))
+	}
+
+	vm.addDevice(SlotHostBridge, 0, "hostbridge").
+		set("model", "i440fx")
+	vm.addDisk(SlotBootDisk, d.config, d.state.Get("bhyve_disk_path").(string),
+		d.config.bootDiskOptions())
+
+	// Emulated NICs need to use the MAC address of the VNIC unless one
+	// has been configured, otherwise bhyve makes one up.
+	mac_address := d.config.MACAddress
+	if mac_address == "" && d.config.NetDevice != "virtio-net-viona" {
+		mac_address = get_vnic_mac(d.config.VNICName)
+	}
+	vm.addNIC(SlotNIC, d.config.NetDevice, d.config.VNICName, mac_address)
+
+	vm.addDevice(SlotLPC, 0, "lpc")
+
+	// Additional disks are placed in the free slots in the order they are
+	// listed in disk_additional_size.
+	slots := d.config.freePCISlots()
+	additional_disks, _ := d.state.Get("bhyve_additional_disk_paths").([]string)
+	for _, disk_path := range additional_disks {
+		vm.addDisk(slots[0], d.config, disk_path, d.config.additionalDiskOptions())
+		slots = slots[1:]
+	}
+
+	// Followed by any additional NICs.
+	for _, nic := range d.config.AdditionalNICs {
+		mac_address := nic.MACAddress
+		if mac_address == "" && nic.NetDevice != "virtio-net-viona" {
+			mac_address = get_vnic_mac(nic.Name)
+		}
+		vm.addNIC(slots[0], nic.NetDevice, nic.Name, mac_address)
+		slots = slots[1:]
+	}
+
+	// Headless guests do not need a framebuffer at all.
+	if !d.config.VNCConfig.DisableVNC {
+		vm.addDevice(SlotFBuf, 0, "fbuf").
+			set("vga", "off").
+			set("tcp", net.JoinHostPort(d.config.VNCBindAddress,
+				strconv.Itoa(d.state.Get("vnc_port").(int)))).
+			set("password", d.state.Get("vnc_password").(string))
+		vm.addDevice(SlotFBuf, 1, "xhci").
+			set("slot.1.device", "tablet")
+	}
+
+	// cd_path is generated if cd_files is specified, use it for both the
+	// initial boot and post-reboot.
+	extra_cd_path, ok := d.state.Get("cd_path").(string)
+	if ok && extra_cd_path != "" {
+		vm.addCD(SlotCDROM2, extra_cd_path)
+	}
+
+	return vm, nil
+}
+
+// renderBhyveArgs interpolates bhyve_args with the details of the VM.
+func (d *BhyveDriver) renderBhyveArgs() ([][]string, error) {
+	vncPort, _ := d.state.Get("vnc_port").(int)
+
+	ctx := d.config.ctx
+	ctx.Data = &bhyveArgsTemplateData{
+		Name:     d.config.VMName,
+		DiskPath: d.state.Get("bhyve_disk_path").(string),
+		VNCPort:  vncPort,
+		ISOPath:  d.state.Get("iso_path").(string),
+	}
+
+	args := [][]string{}
+	for _, arg := range d.config.BhyveArgs {
+		rendered := []string{}
+		for _, value := range arg {
+			value, err := interpolate.Render(value, &ctx)
+			if err != nil {
+				return nil, fmt.Errorf("Error interpolating bhyve_args: %s", err)
+			}
+			rendered = append(rendered, value)
+		}
+		args = append(args, rendered)
+	}
+
+	return args, nil
+}
+
+// Stop shuts the VM down, first by pressing the ACPI power button and
+// giving the guest shutdown_timeout to power off, then by forcing a power
+// off, and finally by killing bhyve.  If ctx is cancelled while waiting for
+// the guest, the power off is forced straight away.
+func (d *BhyveDriver) Stop(ctx context.Context) error {
 	d.lock.Lock()
-	defer d.lock.Unlock()
+	doneCh := d.vmDoneCh
+	d.lock.Unlock()
 
-	if d.vmCmd != nil {
-		if err := d.vmCmd.Process.Kill(); err != nil {
+	if doneCh == nil {
+		return nil
+	}
+
+	ui := d.state.Get("ui").(packer.Ui)
+
+	d.procLock.Lock()
+	d.stopping = true
+	proc := d.proc
+	d.procLock.Unlock()
+
+	// bhyve turns SIGTERM into an ACPI power button press.
+	ui.Say(fmt.Sprintf("Requesting ACPI power off, waiting up to %s...",
+		d.config.ShutdownTimeout))
+	if proc != nil {
+		if err := proc.Signal(syscall.SIGTERM); err != nil {
+			log.Printf("Error sending SIGTERM to bhyve: %s", err)
+		}
+	}
+	select {
+	case <-doneCh:
+		ui.Say("VM stopped by ACPI power off")
+		return nil
+	case <-time.After(d.config.ShutdownTimeout):
+		ui.Say("Guest did not power off, forcing power off...")
+	case <-ctx.Done():
+		ui.Say("Cancelled, forcing power off...")
+	}
+
+	if err := d.bhyvectl("--force-poweroff"); err != nil {
+		log.Printf("Error forcing power off: %s", err)
+	}
+	select {
+	case <-doneCh:
+		ui.Say("VM stopped by forced power off")
+		return nil
+	case <-time.After(10 * time.Second):
+	}
+
+	ui.Say("VM still running, destroying it...")
+	d.procLock.Lock()
+	proc = d.proc
+	d.procLock.Unlock()
+	if proc != nil {
+		if err := proc.Kill(); err != nil {
 			return err
 		}
 	}
+	if err := d.bhyvectl("--destroy"); err != nil {
+		log.Printf("Error destroying VM: %s", err)
+	}
+	select {
+	case <-doneCh:
+		ui.Say("VM stopped by destroying it")
+		return nil
+	case <-time.After(10 * time.Second):
+		return fmt.Errorf("Timeout waiting for VM %s to stop", d.config.VMName)
+	}
+}
+
+func (d *BhyveDriver) bhyvectl(args ...string) error {
+	args = append([]string{fmt.Sprintf("--vm=%s", d.config.VMName)}, args...)
+
+	cmd := exec.Command("/usr/sbin/bhyvectl", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(stderr.String()))
+	}
 
 	return nil
 }
@@ -207,3 +621,10 @@ func (d *BhyveDriver) WaitForShutdown(cancelCh <-chan struct{}) bool {
 		return false
 	}
 }
+
+func (d *BhyveDriver) ExitError() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.vmErr
+}
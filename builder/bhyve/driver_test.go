@@ -0,0 +1,62 @@
+package bhyve
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestExitReasonFromError(t *testing.T) {
+	exit := func(status int) error {
+		return exec.Command("sh", "-c", fmt.Sprintf("exit %d", status)).Run()
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want exitReason
+	}{
+		{"reboot", nil, exitRebooted},
+		{"poweroff", exit(1), exitPoweroff},
+		{"halt", exit(2), exitHalt},
+		{"triple fault", exit(3), exitTripleFault},
+		{"error", exit(4), exitError},
+		{"signal", exec.Command("sh", "-c", "kill -KILL $$").Run(), exitError},
+		{"not an exit status", errors.New("failed"), exitError},
+	}
+
+	for _, tt := range tests {
+		if got := exitReasonFromError(tt.err); got != tt.want {
+			t.Errorf("%s: exitReasonFromError(%v) = %s, want %s", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFreePCISlots(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(c *Config)
+		first  []int
+		count  int
+	}{
+		{"defaults", func(c *Config) {}, []int{5, 7, 8}, 24},
+		{"cd_files", func(c *Config) { c.CDFiles = []string{"seed"} }, []int{5, 8, 9}, 23},
+		{"headless", func(c *Config) { c.VNCConfig.DisableVNC = true }, []int{5, 7, 8}, 25},
+		{"bhyve_args", func(c *Config) {
+			c.BhyveArgs = [][]string{{"-s", "5,virtio-rnd"}, {"-s", "1:7:0,virtio-rnd"}}
+		}, []int{7, 8, 9}, 23},
+	}
+
+	for _, tt := range tests {
+		c := &Config{}
+		tt.config(c)
+
+		slots := c.freePCISlots()
+		if len(slots) != tt.count || !reflect.DeepEqual(slots[:len(tt.first)], tt.first) {
+			t.Errorf("%s: freePCISlots() = %v, want %d slots starting %v",
+				tt.name, slots, tt.count, tt.first)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package bhyve
+
+import (
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compressionExtension returns the file extension for output_compression.
+func compressionExtension(compression string) string {
+	switch compression {
+	case "gzip":
+		return ".gz"
+	case "zstd":
+		return ".zst"
+	case "xz":
+		return ".xz"
+	default:
+		return ""
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newCompressor returns a writer that compresses to w.
+func newCompressor(compression string, w io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	case "xz":
+		return xz.NewWriter(w)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// exportStream writes stream to file_path, with the extension for
+// compression added, and writes the SHA-256 and SHA-1 digests of the result
+// next to it.  Progress is shown against size, which is the expected length
+// of the uncompressed stream.  It returns the path that was written.
+func exportStream(ui packer.Ui, compression string, stream io.ReadCloser, size int64, file_path string) (string, error) {
+	out_path := file_path + compressionExtension(compression)
+
+	outfile, err := os.Create(out_path)
+	if err != nil {
+		return "", fmt.Errorf("Error creating %s: %s", out_path, err)
+	}
+	defer outfile.Close()
+
+	sha256sum := sha256.New()
+	sha1sum := sha1.New()
+
+	w, err := newCompressor(compression, io.MultiWriter(outfile, sha256sum, sha1sum))
+	if err != nil {
+		return "", fmt.Errorf("Error creating %s compressor: %s", compression, err)
+	}
+
+	body := ui.TrackProgress(filepath.Base(out_path), 0, size, stream)
+	defer body.Close()
+
+	if _, err := io.Copy(w, body); err != nil {
+		return "", fmt.Errorf("Error writing %s: %s", out_path, err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("Error writing %s: %s", out_path, err)
+	}
+	if err := outfile.Close(); err != nil {
+		return "", fmt.Errorf("Error writing %s: %s", out_path, err)
+	}
+
+	return out_path, writeDigests(out_path, sha256sum, sha1sum)
+}
+
+// exportFile exports the disk at disk_path in the same way as exportStream,
+// replacing it with the compressed version if compression is set.  It
+// returns the path of the exported disk.
+func exportFile(ui packer.Ui, compression string, disk_path string) (string, error) {
+	f, err := os.Open(disk_path)
+	if err != nil {
+		return "", fmt.Errorf("Error opening disk: %s", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("Error opening disk: %s", err)
+	}
+
+	// Without compression there is nothing to write, only the digests.
+	if compression == "" {
+		return disk_path, digestFile(disk_path)
+	}
+
+	ui.Say(fmt.Sprintf("Compressing %s with %s", disk_path, compression))
+
+	out_path, err := exportStream(ui, compression, f, info.Size(), disk_path)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+
+	if err := os.Remove(disk_path); err != nil {
+		return "", fmt.Errorf("Error removing uncompressed disk: %s", err)
+	}
+
+	return out_path, nil
+}
+
+// exportRaw writes a raw image of the disk at dev_path, which is size bytes,
+// to file_path.  Without compression the image is sparse, otherwise it is
+// compressed by exportStream.  Either way the digests are written next to
+// it, and the path that was written is returned.
+func exportRaw(ui packer.Ui, compression string, dev_path string, size int64, file_path string) (string, error) {
+	dev, err := os.Open(dev_path)
+	if err != nil {
+		return "", fmt.Errorf("Error opening disk: %s", err)
+	}
+	defer dev.Close()
+
+	if compression != "" {
+		return exportStream(ui, compression, dev, size, file_path)
+	}
+
+	outfile, err := os.Create(file_path)
+	if err != nil {
+		return "", fmt.Errorf("Error creating %s: %s", file_path, err)
+	}
+	defer outfile.Close()
+
+	// Set the size first, so that a trailing run of zeros is a hole too.
+	if err := outfile.Truncate(size); err != nil {
+		return "", fmt.Errorf("Error creating %s: %s", file_path, err)
+	}
+
+	sha256sum := sha256.New()
+	sha1sum := sha1.New()
+
+	body := ui.TrackProgress(filepath.Base(file_path), 0, size, dev)
+	defer body.Close()
+
+	if err := copySparse(outfile, body, io.MultiWriter(sha256sum, sha1sum)); err != nil {
+		return "", fmt.Errorf("Error writing %s: %s", file_path, err)
+	}
+	if err := outfile.Close(); err != nil {
+		return "", fmt.Errorf("Error writing %s: %s", file_path, err)
+	}
+
+	return file_path, writeDigests(file_path, sha256sum, sha1sum)
+}
+
+// convertDisk writes the disk at src_path, which is size bytes, to
+// file_path as a qcow2 image or stream-optimized VMDK.  The result is checked
+// by reading its header back, and its digests are written next to it.
+func convertDisk(ui packer.Ui, format string, src_path string, size int64, file_path string, compress bool) (string, error) {
+	src, err := os.Open(src_path)
+	if err != nil {
+		return "", fmt.Errorf("Error opening disk: %s", err)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(file_path)
+	if err != nil {
+		return "", fmt.Errorf("Error creating %s: %s", file_path, err)
+	}
+	defer dst.Close()
+
+	ui.Say(fmt.Sprintf("Converting %s to %s", src_path, file_path))
+
+	body := ui.TrackProgress(filepath.Base(file_path), 0, size, src)
+	defer body.Close()
+
+	var check func(string, int64) error
+	switch format {
+	case "qcow2":
+		err = writeQcow2(dst, body, size, compress)
+		check = checkQcow2
+	case "vmdk":
+		err = writeVMDK(dst, body, size)
+		check = checkVMDK
+	default:
+		err = fmt.Errorf("unknown format %s", format)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error writing %s: %s", file_path, err)
+	}
+	if err := dst.Close(); err != nil {
+		return "", fmt.Errorf("Error writing %s: %s", file_path, err)
+	}
+
+	if err := check(file_path, size); err != nil {
+		return "", fmt.Errorf("Error checking %s: %s", file_path, err)
+	}
+
+	return file_path, digestFile(file_path)
+}
+
+// digestFile writes the digests of the file at path next to it.
+func digestFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Error opening %s: %s", path, err)
+	}
+	defer f.Close()
+
+	sha256sum := sha256.New()
+	sha1sum := sha1.New()
+	if _, err := io.Copy(io.MultiWriter(sha256sum, sha1sum), f); err != nil {
+		return fmt.Errorf("Error reading %s: %s", path, err)
+	}
+
+	return writeDigests(path, sha256sum, sha1sum)
+}
+
+// writeDigests writes the digests of path to path.sha256 and path.sha1, in
+// the format used by sha256sum and sha1sum.
+func writeDigests(path string, sha256sum hash.Hash, sha1sum hash.Hash) error {
+	digests := map[string]hash.Hash{
+		".sha256": sha256sum,
+		".sha1":   sha1sum,
+	}
+
+	for ext, h := range digests {
+		line := fmt.Sprintf("%x  %s\n", h.Sum(nil), filepath.Base(path))
+		if err := os.WriteFile(path+ext, []byte(line), 0644); err != nil {
+			return fmt.Errorf("Error writing digest %s: %s", path+ext, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package bhyve
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+// GuestConfig holds bhyve flags whose best setting depends on the guest
+// operating system.  Any that are not set explicitly get a default based on
+// guest_os_type.
+type GuestConfig struct {
+	GuestOSType    string         `mapstructure:"guest_os_type" required:"false"`
+	ACPI           config.Trilean `mapstructure:"acpi" required:"false"`
+	DisableMPTable bool           `mapstructure:"disable_mptable" required:"false"`
+	IgnoreMSRs     config.Trilean `mapstructure:"ignore_unimplemented_msr" required:"false"`
+	RTCUTC         config.Trilean `mapstructure:"rtc_utc" required:"false"`
+	X2APIC         bool           `mapstructure:"x2apic" required:"false"`
+}
+
+func (c *GuestConfig) Prepare(ctx *interpolate.Context) (errs []error) {
+	if c.GuestOSType == "" {
+		c.GuestOSType = "other"
+	}
+
+	bsd := false
+	switch c.GuestOSType {
+	case "freebsd", "netbsd", "openbsd":
+		bsd = true
+	case "linux", "illumos", "windows", "other":
+	default:
+		errs = append(errs, fmt.Errorf(
+			"guest_os_type must be one of linux, freebsd, netbsd, openbsd, illumos, windows or other"))
+	}
+
+	if c.ACPI == config.TriUnset {
+		c.ACPI = config.TriTrue
+	}
+
+	// Some BSD guests panic when an unimplemented MSR faults.
+	if c.IgnoreMSRs == config.TriUnset {
+		if bsd {
+			c.IgnoreMSRs = config.TriTrue
+		} else {
+			c.IgnoreMSRs = config.TriFalse
+		}
+	}
+
+	// Windows expects the RTC to be in local time, everything else UTC.
+	if c.RTCUTC == config.TriUnset {
+		if c.GuestOSType == "windows" {
+			c.RTCUTC = config.TriFalse
+		} else {
+			c.RTCUTC = config.TriTrue
+		}
+	}
+
+	return
+}
+
+// configure sets the guest dependent options.
+func (c GuestConfig) configure(vm *vmConfig) {
+	if c.ACPI.True() {
+		vm.set("acpi_tables", "true")
+	}
+	if c.RTCUTC.True() {
+		vm.set("rtc.use_localtime", "false")
+	}
+	if c.IgnoreMSRs.True() {
+		vm.set("x86.strictmsr", "false")
+	}
+	if c.X2APIC {
+		vm.set("x86.x2apic", "true")
+	}
+	if c.DisableMPTable {
+		vm.set("x86.mptable", "false")
+	}
+}
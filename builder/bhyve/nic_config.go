@@ -0,0 +1,61 @@
+package bhyve
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+// NICConfig describes an additional network interface, attached to a VNIC
+// on another host link.
+type NICConfig struct {
+	Link       string `mapstructure:"link" required:"false"`
+	MACAddress string `mapstructure:"mac_address" required:"false"`
+	Name       string `mapstructure:"name" required:"false"`
+	NetDevice  string `mapstructure:"net_device" required:"false"`
+}
+
+func (c *NICConfig) Prepare(ctx *interpolate.Context, config *Config, index int) (errs []error) {
+	if c.Link == "" {
+		c.Link = config.VNICLink
+	}
+
+	// illumos link names must end in a number.
+	if c.Name == "" {
+		c.Name = fmt.Sprintf("%s_%d", config.VNICName, index+1)
+	}
+
+	if c.NetDevice == "" {
+		c.NetDevice = config.NetDevice
+	}
+
+	errs = append(errs, validateNIC(
+		fmt.Sprintf("additional_nics[%d]", index),
+		c.NetDevice, c.MACAddress, config.VNICCreate)...)
+
+	return
+}
+
+func validateNIC(prefix string, device string, mac string, create bool) (errs []error) {
+	switch device {
+	case "virtio-net-viona", "virtio-net", "e1000":
+	default:
+		errs = append(errs, fmt.Errorf(
+			"%s: net_device must be one of virtio-net-viona, virtio-net or e1000", prefix))
+	}
+
+	if mac != "" {
+		if _, err := net.ParseMAC(mac); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid mac_address: %s", prefix, err))
+		}
+
+		// viona always uses the MAC address of the VNIC.
+		if device == "virtio-net-viona" && !create {
+			errs = append(errs, fmt.Errorf(
+				"%s: mac_address with virtio-net-viona requires vnic_create", prefix))
+		}
+	}
+
+	return
+}
@@ -0,0 +1,423 @@
+package bhyve
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// See docs/interop/qcow2.txt in the QEMU source for the format.
+const (
+	qcow2Version       = 2
+	qcow2ClusterBits   = 16
+	qcow2ClusterSize   = 1 << qcow2ClusterBits
+	qcow2HeaderSize    = 72
+	qcow2L2Entries     = qcow2ClusterSize / 8
+	qcow2RefcountsPer  = qcow2ClusterSize / 2
+	qcow2CsizeShift    = 62 - (qcow2ClusterBits - 8)
+	qcow2OflagCopied   = 1 << 63
+	qcow2OflagCompress = 1 << 62
+
+	// QEMU inflates compressed clusters with a 4 KiB window, so no match
+	// may reach further back than this.
+	qcow2DeflateWindow = 4096
+)
+
+var qcow2Magic = []byte{'Q', 'F', 'I', 0xfb}
+
+// qcow2Writer lays out a qcow2 image in a single pass.  Data clusters are
+// written directly after the header as they are read, followed by the L2
+// tables, the L1 table and finally the refcounts, which are only known once
+// all of the data has been written.
+type qcow2Writer struct {
+	dst       *os.File
+	offset    int64
+	l2        [][]uint64
+	refcounts []uint16
+}
+
+// ref counts a reference to each host cluster in the length bytes at
+// offset.
+func (w *qcow2Writer) ref(offset int64, length int64) {
+	last := (offset + length - 1) / qcow2ClusterSize
+	for int64(len(w.refcounts)) <= last {
+		w.refcounts = append(w.refcounts, 0)
+	}
+	for c := offset / qcow2ClusterSize; c <= last; c++ {
+		w.refcounts[c]++
+	}
+}
+
+// align moves the write offset to the start of the next host cluster.
+func (w *qcow2Writer) align() {
+	w.offset = (w.offset + qcow2ClusterSize - 1) &^ (qcow2ClusterSize - 1)
+}
+
+func (w *qcow2Writer) write(data []byte) (int64, error) {
+	offset := w.offset
+	if len(data) == 0 {
+		return offset, nil
+	}
+	if _, err := w.dst.WriteAt(data, offset); err != nil {
+		return 0, err
+	}
+	w.ref(offset, int64(len(data)))
+	w.offset += int64(len(data))
+	return offset, nil
+}
+
+func (w *qcow2Writer) setL2(cluster int64, entry uint64) {
+	table := cluster / qcow2L2Entries
+	if w.l2[table] == nil {
+		w.l2[table] = make([]uint64, qcow2L2Entries)
+	}
+	w.l2[table][cluster%qcow2L2Entries] = entry
+}
+
+// writeQcow2 writes the size bytes read from src to dst as a qcow2 image.
+// Clusters of zeros are left unallocated, and if compress is set the rest
+// are deflate compressed where that makes them smaller.
+func writeQcow2(dst *os.File, src io.Reader, size int64, compress bool) error {
+	clusters := (size + qcow2ClusterSize - 1) / qcow2ClusterSize
+	l1Size := (clusters + qcow2L2Entries - 1) / qcow2L2Entries
+
+	w := &qcow2Writer{
+		dst: dst,
+		l2:  make([][]uint64, l1Size),
+	}
+
+	// The header occupies the first cluster.
+	w.ref(0, qcow2ClusterSize)
+	w.offset = qcow2ClusterSize
+
+	buf := make([]byte, qcow2ClusterSize)
+	zero := make([]byte, qcow2ClusterSize)
+	var compressed bytes.Buffer
+	deflater, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		return fmt.Errorf("Error creating compressor: %s", err)
+	}
+
+	for cluster := int64(0); cluster < clusters; cluster++ {
+		n, err := io.ReadFull(src, buf)
+		if err == io.ErrUnexpectedEOF && cluster == clusters-1 {
+			copy(buf[n:], zero)
+		} else if err != nil {
+			return fmt.Errorf("Error reading disk: %s", err)
+		}
+
+		if bytes.Equal(buf, zero) {
+			continue
+		}
+
+		// Compressed clusters are packed together at any byte offset.
+		if compress {
+			compressed.Reset()
+			if err := deflateCluster(deflater, &compressed, buf); err != nil {
+				return fmt.Errorf("Error compressing cluster: %s", err)
+			}
+
+			if compressed.Len() < qcow2ClusterSize {
+				offset, err := w.write(compressed.Bytes())
+				if err != nil {
+					return err
+				}
+				end := offset + int64(compressed.Len()) - 1
+				sectors := uint64(end>>9 - offset>>9)
+				w.setL2(cluster, qcow2OflagCompress|sectors<<qcow2CsizeShift|uint64(offset))
+				continue
+			}
+		}
+
+		w.align()
+		offset, err := w.write(buf)
+		if err != nil {
+			return err
+		}
+		w.setL2(cluster, qcow2OflagCopied|uint64(offset))
+	}
+
+	return w.finish(size)
+}
+
+// deflateCluster writes buf to dst as a raw deflate stream that QEMU can
+// read.  Go's encoder uses a 32 KiB window, so each 4 KiB of the cluster is
+// compressed by a fresh encoder and ended with a sync flush rather than the
+// final block, which keeps every match within the window QEMU allows while
+// the blocks still form a single stream.
+func deflateCluster(deflater *flate.Writer, dst *bytes.Buffer, buf []byte) error {
+	for start := 0; start < len(buf); start += qcow2DeflateWindow {
+		end := start + qcow2DeflateWindow
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		deflater.Reset(dst)
+		if _, err := deflater.Write(buf[start:end]); err != nil {
+			return err
+		}
+
+		var err error
+		if end == len(buf) {
+			err = deflater.Close()
+		} else {
+			err = deflater.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// finish writes the metadata after the data clusters, and then the header.
+func (w *qcow2Writer) finish(size int64) error {
+	w.align()
+
+	l2Tables := 0
+	for _, table := range w.l2 {
+		if table != nil {
+			l2Tables++
+		}
+	}
+	l1Clusters := (int64(len(w.l2))*8 + qcow2ClusterSize - 1) / qcow2ClusterSize
+
+	// The refcounts have to cover themselves as well, so find how many
+	// blocks are needed for everything including them.
+	base := w.offset/qcow2ClusterSize + int64(l2Tables) + l1Clusters
+	blocks, tableClusters := int64(1), int64(1)
+	for {
+		total := base + tableClusters + blocks
+		needBlocks := (total + qcow2RefcountsPer - 1) / qcow2RefcountsPer
+		needTable := (needBlocks*8 + qcow2ClusterSize - 1) / qcow2ClusterSize
+		if needBlocks == blocks && needTable == tableClusters {
+			break
+		}
+		blocks, tableClusters = needBlocks, needTable
+	}
+
+	// L2 tables, and the L1 table pointing at them.
+	l1 := make([]byte, l1Clusters*qcow2ClusterSize)
+	table := make([]byte, qcow2ClusterSize)
+	for i, entries := range w.l2 {
+		if entries == nil {
+			continue
+		}
+		for j, entry := range entries {
+			binary.BigEndian.PutUint64(table[j*8:], entry)
+		}
+		offset, err := w.write(table)
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(l1[i*8:], qcow2OflagCopied|uint64(offset))
+	}
+
+	l1Offset, err := w.write(l1)
+	if err != nil {
+		return err
+	}
+
+	// Reserve the refcount table and blocks so that they are counted,
+	// then write them out.
+	refcountTableOffset := w.offset
+	blocksOffset := refcountTableOffset + tableClusters*qcow2ClusterSize
+	w.ref(refcountTableOffset, (tableClusters+blocks)*qcow2ClusterSize)
+
+	refcountTable := make([]byte, tableClusters*qcow2ClusterSize)
+	refcountBlocks := make([]byte, blocks*qcow2ClusterSize)
+	for i := int64(0); i < blocks; i++ {
+		binary.BigEndian.PutUint64(refcountTable[i*8:],
+			uint64(blocksOffset+i*qcow2ClusterSize))
+	}
+	for i, refcount := range w.refcounts {
+		binary.BigEndian.PutUint16(refcountBlocks[i*2:], refcount)
+	}
+
+	if _, err := w.dst.WriteAt(refcountTable, refcountTableOffset); err != nil {
+		return err
+	}
+	if _, err := w.dst.WriteAt(refcountBlocks, blocksOffset); err != nil {
+		return err
+	}
+
+	header := make([]byte, qcow2HeaderSize)
+	copy(header[0:], qcow2Magic)
+	binary.BigEndian.PutUint32(header[4:], qcow2Version)
+	binary.BigEndian.PutUint32(header[20:], qcow2ClusterBits)
+	binary.BigEndian.PutUint64(header[24:], uint64(size))
+	binary.BigEndian.PutUint32(header[36:], uint32(len(w.l2)))
+	binary.BigEndian.PutUint64(header[40:], uint64(l1Offset))
+	binary.BigEndian.PutUint64(header[48:], uint64(refcountTableOffset))
+	binary.BigEndian.PutUint32(header[56:], uint32(tableClusters))
+
+	if _, err := w.dst.WriteAt(header, 0); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkQcow2 reads back the header of the qcow2 image at path, and checks
+// that it describes a disk of size bytes.
+func checkQcow2(path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, qcow2HeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return fmt.Errorf("%s: cannot read qcow2 header: %s", path, err)
+	}
+
+	if !bytes.Equal(header[0:4], qcow2Magic) {
+		return fmt.Errorf("%s: bad qcow2 magic", path)
+	}
+	if v := binary.BigEndian.Uint32(header[4:]); v != 2 && v != 3 {
+		return fmt.Errorf("%s: unsupported qcow2 version %d", path, v)
+	}
+	if v := binary.BigEndian.Uint64(header[24:]); v != uint64(size) {
+		return fmt.Errorf("%s: qcow2 size is %d, expected %d", path, v, size)
+	}
+
+	clusterBits := binary.BigEndian.Uint32(header[20:])
+	if clusterBits < 9 || clusterBits > 21 {
+		return fmt.Errorf("%s: invalid qcow2 cluster size", path)
+	}
+	l1End := binary.BigEndian.Uint64(header[40:]) +
+		uint64(binary.BigEndian.Uint32(header[36:]))*8
+	refcountEnd := binary.BigEndian.Uint64(header[48:]) +
+		uint64(binary.BigEndian.Uint32(header[56:]))<<clusterBits
+	if l1End > uint64(info.Size()) || refcountEnd > uint64(info.Size()) {
+		return fmt.Errorf("%s: qcow2 tables extend past the end of the file", path)
+	}
+
+	return nil
+}
+
+// The offset bits of L1 and uncompressed L2 entries, and the zero flag of
+// version 3 L2 entries.
+const (
+	qcow2OffsetMask = 0x00fffffffffffe00
+	qcow2OflagZero  = 1
+)
+
+// openQcow2 returns a reader for the virtual disk contents of a qcow2
+// image, and their size.  Images with a backing file, encryption or an
+// external data file are not supported.
+func openQcow2(f *os.File) (io.Reader, int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	header := make([]byte, 104)
+	if _, err := f.ReadAt(header, 0); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+
+	be := binary.BigEndian
+	version := be.Uint32(header[4:])
+	if version != 2 && version != 3 {
+		return nil, 0, fmt.Errorf("unsupported qcow2 version %d", version)
+	}
+	if be.Uint64(header[8:]) != 0 {
+		return nil, 0, fmt.Errorf("qcow2 images with a backing file are not supported")
+	}
+	if be.Uint32(header[32:]) != 0 {
+		return nil, 0, fmt.Errorf("encrypted qcow2 images are not supported")
+	}
+
+	// Only the dirty bit is harmless, as the refcounts are not used.
+	if version == 3 {
+		if features := be.Uint64(header[72:]); features&^1 != 0 {
+			return nil, 0, fmt.Errorf("unsupported qcow2 features %#x", features)
+		}
+	}
+
+	clusterBits := be.Uint32(header[20:])
+	if clusterBits < 9 || clusterBits > 21 {
+		return nil, 0, fmt.Errorf("invalid qcow2 cluster size")
+	}
+	clusterSize := int64(1) << clusterBits
+	size := int64(be.Uint64(header[24:]))
+	if size < 0 {
+		return nil, 0, fmt.Errorf("invalid qcow2 size")
+	}
+	l2Entries := clusterSize / 8
+	csizeShift := 62 - (clusterBits - 8)
+	csizeMask := uint64(1)<<(clusterBits-8) - 1
+
+	l1Offset := int64(be.Uint64(header[40:]))
+	l1Count := int64(be.Uint32(header[36:]))
+	if err := checkImageTable(l1Offset, l1Count, 8, info.Size()); err != nil {
+		return nil, 0, fmt.Errorf("invalid qcow2 L1 table: %s", err)
+	}
+
+	l1 := make([]uint64, l1Count)
+	if err := binary.Read(io.NewSectionReader(f, l1Offset, l1Count*8), be, l1); err != nil {
+		return nil, 0, fmt.Errorf("cannot read qcow2 L1 table: %s", err)
+	}
+
+	// Cache the L2 table in use, as the clusters are read in order.
+	l2 := make([]uint64, l2Entries)
+	l2Index := int64(-1)
+	compressed := make([]byte, 2*clusterSize)
+
+	read := func(index int64, buf []byte) error {
+		table := index / l2Entries
+		if table >= int64(len(l1)) {
+			return nil
+		}
+		l2Offset := int64(l1[table] & qcow2OffsetMask)
+		if l2Offset == 0 {
+			return nil
+		}
+		if table != l2Index {
+			if err := binary.Read(io.NewSectionReader(f, l2Offset, clusterSize), be, l2); err != nil {
+				return fmt.Errorf("cannot read qcow2 L2 table: %s", err)
+			}
+			l2Index = table
+		}
+
+		entry := l2[index%l2Entries]
+		if entry&qcow2OflagCompress != 0 {
+			offset := int64(entry & (1<<csizeShift - 1))
+			sectors := int64((entry>>csizeShift)&csizeMask) + 1
+			length := sectors*512 - offset&511
+
+			n, err := f.ReadAt(compressed[:length], offset)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			inflater := flate.NewReader(bytes.NewReader(compressed[:n]))
+			defer inflater.Close()
+			if _, err := io.ReadFull(inflater, buf); err != nil {
+				return fmt.Errorf("cannot decompress qcow2 cluster %d: %s", index, err)
+			}
+			return nil
+		}
+
+		offset := int64(entry & qcow2OffsetMask)
+		if offset == 0 || (version == 3 && entry&qcow2OflagZero != 0) {
+			return nil
+		}
+		if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	}
+
+	return newClusterReader(size, clusterSize, read), size, nil
+}
@@ -0,0 +1,106 @@
+package bhyve
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// repeatedDisk returns a disk of size bytes in which a random 1 KiB pattern
+// repeats every 6 KiB, so that a deflate encoder with a window of more than
+// 4 KiB would find matches further back than QEMU allows.
+func repeatedDisk(size int) []byte {
+	pattern := make([]byte, 1024)
+	rand.New(rand.NewSource(1)).Read(pattern)
+
+	disk := make([]byte, size)
+	for offset := 0; offset+len(pattern) <= size; offset += 6 * 1024 {
+		copy(disk[offset:], pattern)
+	}
+
+	return disk
+}
+
+func TestQcow2RoundTrip(t *testing.T) {
+	// Three clusters of data, a cluster of zeros and a partial cluster.
+	disk := repeatedDisk(4*qcow2ClusterSize + 1000)
+	for i := 3 * qcow2ClusterSize; i < 4*qcow2ClusterSize; i++ {
+		disk[i] = 0
+	}
+
+	for _, compress := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "disk.qcow2")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeQcow2(f, bytes.NewReader(disk), int64(len(disk)), compress); err != nil {
+			t.Fatalf("compress=%v: %s", compress, err)
+		}
+		f.Close()
+
+		if err := checkQcow2(path, int64(len(disk))); err != nil {
+			t.Fatalf("compress=%v: %s", compress, err)
+		}
+
+		f, err = os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, size, err := openQcow2(f)
+		if err != nil {
+			t.Fatalf("compress=%v: %s", compress, err)
+		}
+		if size != int64(len(disk)) {
+			t.Fatalf("compress=%v: size %d, want %d", compress, size, len(disk))
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("compress=%v: %s", compress, err)
+		}
+		f.Close()
+
+		if !bytes.Equal(got, disk) {
+			t.Fatalf("compress=%v: contents differ", compress)
+		}
+	}
+}
+
+func TestQcow2Corrupt(t *testing.T) {
+	disk := repeatedDisk(2 * qcow2ClusterSize)
+	path := filepath.Join(t.TempDir(), "disk.qcow2")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeQcow2(f, bytes.NewReader(disk), int64(len(disk)), false); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	image, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		value  uint64
+		size   int
+	}{
+		{"negative size", 24, 1 << 63, 8},
+		{"huge L1 table", 36, 0xffffffff, 4},
+		{"L1 table past the end", 40, uint64(len(image)), 8},
+		{"negative L1 offset", 40, 1 << 63, 8},
+	}
+
+	for _, tt := range tests {
+		if err := openCorruptImage(t, image, tt.offset, tt.value, tt.size, binary.BigEndian); err == nil {
+			t.Errorf("%s: opened without error", tt.name)
+		}
+	}
+}
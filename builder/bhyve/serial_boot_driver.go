@@ -0,0 +1,134 @@
+package bhyve
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
+)
+
+// serialBootDriver implements bootcommand.BCDriver by writing characters and
+// terminal escape sequences to the guest serial console, so that the usual
+// boot_command syntax can be used with headless guests.
+type serialBootDriver struct {
+	w          io.Writer
+	interval   time.Duration
+	specialMap map[string]string
+	ctrl       bool
+	alt        bool
+	err        error
+}
+
+func newSerialBootDriver(w io.Writer, interval time.Duration) *serialBootDriver {
+	// Use the same key interval logic as the VNC driver.
+	keyInterval := bootcommand.PackerKeyDefault
+	if delay, err := time.ParseDuration(os.Getenv(bootcommand.PackerKeyEnv)); err == nil {
+		keyInterval = delay
+	}
+	if interval > time.Duration(0) {
+		keyInterval = interval
+	}
+
+	// VT100/xterm sequences, which is what most serial consoles expect.
+	sMap := make(map[string]string)
+	sMap["bs"] = "\x7f"
+	sMap["del"] = "\x1b[3~"
+	sMap["down"] = "\x1b[B"
+	sMap["end"] = "\x1b[F"
+	sMap["enter"] = "\r"
+	sMap["esc"] = "\x1b"
+	sMap["f1"] = "\x1bOP"
+	sMap["f2"] = "\x1bOQ"
+	sMap["f3"] = "\x1bOR"
+	sMap["f4"] = "\x1bOS"
+	sMap["f5"] = "\x1b[15~"
+	sMap["f6"] = "\x1b[17~"
+	sMap["f7"] = "\x1b[18~"
+	sMap["f8"] = "\x1b[19~"
+	sMap["f9"] = "\x1b[20~"
+	sMap["f10"] = "\x1b[21~"
+	sMap["f11"] = "\x1b[23~"
+	sMap["f12"] = "\x1b[24~"
+	sMap["home"] = "\x1b[H"
+	sMap["insert"] = "\x1b[2~"
+	sMap["left"] = "\x1b[D"
+	sMap["pagedown"] = "\x1b[6~"
+	sMap["pageup"] = "\x1b[5~"
+	sMap["return"] = "\r"
+	sMap["right"] = "\x1b[C"
+	sMap["spacebar"] = " "
+	sMap["tab"] = "\t"
+	sMap["up"] = "\x1b[A"
+
+	return &serialBootDriver{
+		w:          w,
+		interval:   keyInterval,
+		specialMap: sMap,
+	}
+}
+
+func (d *serialBootDriver) write(s string) error {
+	if d.err != nil {
+		return d.err
+	}
+	if _, err := io.WriteString(d.w, s); err != nil {
+		d.err = err
+		return err
+	}
+	time.Sleep(d.interval)
+	return nil
+}
+
+// Flush does nothing here
+func (d *serialBootDriver) Flush() error {
+	return nil
+}
+
+func (d *serialBootDriver) SendKey(key rune, action bootcommand.KeyAction) error {
+	// There are no key releases on a serial line.
+	if action == bootcommand.KeyOff {
+		return d.err
+	}
+
+	s := string(key)
+	if d.ctrl {
+		// Map to the control character, e.g. <leftCtrlOn>c<leftCtrlOff>
+		// sends ^C.
+		s = string(key & 0x1f)
+	}
+	if d.alt {
+		s = "\x1b" + s
+	}
+	log.Printf("Sending char '%c' to serial console, ctrl %v, alt %v", key, d.ctrl, d.alt)
+
+	return d.write(s)
+}
+
+func (d *serialBootDriver) SendSpecial(special string, action bootcommand.KeyAction) error {
+	// Modifiers only change how subsequent keys are sent.
+	switch special {
+	case "leftctrl", "rightctrl":
+		d.ctrl = action == bootcommand.KeyOn
+		return d.err
+	case "leftalt", "rightalt":
+		d.alt = action == bootcommand.KeyOn
+		return d.err
+	case "leftshift", "rightshift", "leftsuper", "rightsuper", "menu":
+		return d.err
+	}
+
+	seq, ok := d.specialMap[special]
+	if !ok {
+		return fmt.Errorf("special %s not found.", special)
+	}
+	log.Printf("Special code '<%s>' found, sending %q to serial console", special, seq)
+
+	if action == bootcommand.KeyOff {
+		return d.err
+	}
+
+	return d.write(seq)
+}
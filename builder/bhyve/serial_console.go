@@ -0,0 +1,248 @@
+package bhyve
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serialConsole streams the output of the guest com1 port into a log file
+// and the Packer log.  bhyve creates the com1 socket each time it starts, so
+// the driver calls connect() after every (re)start of the VM and the console
+// carries on writing to the same file.
+type serialConsole struct {
+	socketPath string
+	logPath    string
+	logFile    *os.File
+	conn       net.Conn
+	lock       sync.Mutex
+
+	// Output not yet consumed by expect(), the most recent output for
+	// error reporting, and a channel that is closed when more arrives.
+	pending []byte
+	recent  []byte
+	notify  chan struct{}
+}
+
+const (
+	// Limits on the amount of console output kept in memory.
+	serialPendingMax = 64 * 1024
+	serialRecentMax  = 4 * 1024
+)
+
+// Matches ANSI CSI escape sequences, such as the cursor movement and colour
+// codes that installers draw their menus and prompts with.
+var csiPattern = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]`)
+
+// newSerialConsole creates a console for the com1 socket at socketPath.  If
+// logPath is empty the console output is only written to the Packer log.
+func newSerialConsole(socketPath string, logPath string) (*serialConsole, error) {
+	s := &serialConsole{
+		socketPath: socketPath,
+		logPath:    logPath,
+		notify:     make(chan struct{}),
+	}
+
+	if logPath != "" {
+		f, err := os.Create(logPath)
+		if err != nil {
+			return nil, err
+		}
+		s.logFile = f
+	}
+
+	return s, nil
+}
+
+// connect attaches to the com1 socket of a freshly started bhyve process.
+// The socket may not exist immediately after bhyve has been started, so we
+// retry for a short while before giving up.
+func (s *serialConsole) connect() error {
+	var conn net.Conn
+	var err error
+
+	for i := 0; i < 20; i++ {
+		conn, err = net.Dial("unix", s.socketPath)
+		if err == nil {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	if err != nil {
+		return fmt.Errorf("Error connecting to serial console: %s", err)
+	}
+
+	s.lock.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn = conn
+	s.lock.Unlock()
+
+	log.Printf("Connected to serial console %s", s.socketPath)
+	go s.read(conn)
+
+	return nil
+}
+
+// Write sends input to the guest through the currently connected socket.
+func (s *serialConsole) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	conn := s.conn
+	s.lock.Unlock()
+
+	if conn == nil {
+		return 0, errors.New("serial console is not connected")
+	}
+
+	return conn.Write(p)
+}
+
+// waitConnected waits for the driver to attach to the com1 socket.
+func (s *serialConsole) waitConnected(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		s.lock.Lock()
+		conn := s.conn
+		s.lock.Unlock()
+
+		if conn != nil {
+			return nil
+		}
+
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-ctx.Done():
+			return errors.New("Timeout waiting for serial console connection")
+		}
+	}
+}
+
+// expect waits for output matching re to appear on the console.  Output is
+// consumed up to the end of the match, so a following expect() only sees
+// output that arrived after this one matched.  CSI escape sequences are
+// removed before matching, so a prompt drawn with cursor control matches
+// the same pattern as one that is not.  A sequence split across reads is
+// left in place until the rest of it arrives.
+func (s *serialConsole) expect(ctx context.Context, re *regexp.Regexp, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		s.lock.Lock()
+		s.pending = csiPattern.ReplaceAll(s.pending, nil)
+		if loc := re.FindIndex(s.pending); loc != nil {
+			s.pending = s.pending[loc[1]:]
+			s.lock.Unlock()
+			return nil
+		}
+		notify := s.notify
+		s.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return fmt.Errorf("Timeout after %s waiting for %q on serial console, last output:\n%s",
+				timeout, re.String(), strings.Join(s.tail(10), "\n"))
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// tail returns up to the last n lines of console output.
+func (s *serialConsole) tail(n int) []string {
+	s.lock.Lock()
+	recent := string(csiPattern.ReplaceAll(s.recent, nil))
+	s.lock.Unlock()
+
+	lines := strings.Split(strings.ReplaceAll(recent, "\r", ""), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return lines
+}
+
+func appendLimited(buf []byte, p []byte, max int) []byte {
+	buf = append(buf, p...)
+	if len(buf) > max {
+		buf = buf[len(buf)-max:]
+	}
+	return buf
+}
+
+func (s *serialConsole) read(conn net.Conn) {
+	var line bytes.Buffer
+	buf := make([]byte, 4096)
+
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			s.lock.Lock()
+			if s.logFile != nil {
+				s.logFile.Write(buf[:n])
+			}
+			s.pending = appendLimited(s.pending, buf[:n], serialPendingMax)
+			s.recent = appendLimited(s.recent, buf[:n], serialRecentMax)
+			close(s.notify)
+			s.notify = make(chan struct{})
+			s.lock.Unlock()
+
+			for _, c := range buf[:n] {
+				switch c {
+				case '\n':
+					log.Printf("serial: %s", line.String())
+					line.Reset()
+				case '\r':
+				default:
+					line.WriteByte(c)
+				}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Serial console read error: %s", err)
+			}
+			break
+		}
+	}
+
+	if line.Len() > 0 {
+		log.Printf("serial: %s", line.String())
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	conn.Close()
+	if s.conn == conn {
+		s.conn = nil
+	}
+}
+
+func (s *serialConsole) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	if s.logFile != nil {
+		s.logFile.Close()
+		s.logFile = nil
+	}
+}
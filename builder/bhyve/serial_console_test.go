@@ -0,0 +1,38 @@
+package bhyve
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestSerialConsoleExpect(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		pattern string
+		rest    string
+		err     bool
+	}{
+		{"plain", "host console login: ", `login: $`, "", false},
+		{"cursor control", "\x1b[2J\x1b[10;1Hlog\x1b[0min: \x1b[K", `login: $`, "", false},
+		{"consumed", "Password: abc", `Password: `, "abc", false},
+		{"partial sequence", "login: \x1b[1", `login: $`, "", true},
+		{"no match", "booting...", `login: $`, "", true},
+	}
+
+	for _, tt := range tests {
+		s := &serialConsole{notify: make(chan struct{})}
+		s.pending = []byte(tt.output)
+
+		err := s.expect(context.Background(), regexp.MustCompile(tt.pattern), 10*time.Millisecond)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: expect() error = %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && string(s.pending) != tt.rest {
+			t.Errorf("%s: pending = %q, want %q", tt.name, s.pending, tt.rest)
+		}
+	}
+}
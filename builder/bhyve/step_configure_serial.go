@@ -0,0 +1,77 @@
+package bhyve
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+const serialLogName = "serial.log"
+
+// This step sets up the com1 serial console socket and the log file that
+// the console output is written to.
+//
+// Uses:
+//
+//	config *config
+//	ui     packer.Ui
+//
+// Produces:
+//
+//	serial_console *serialConsole - The console attached to com1.
+type stepConfigureSerial struct {
+	dir     string
+	console *serialConsole
+}
+
+func (s *stepConfigureSerial) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	// Keep the socket out of the output directory so that it does not end
+	// up in the artifact.
+	dir, err := os.MkdirTemp("", "packer-bhyve")
+	if err != nil {
+		err := fmt.Errorf("Error creating serial console directory: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	s.dir = dir
+
+	socketPath := filepath.Join(dir, fmt.Sprintf("%s.com1", config.VMName))
+	logPath := ""
+	if config.SerialLog {
+		logPath = filepath.Join(config.OutputDir, serialLogName)
+		ui.Say(fmt.Sprintf("Logging serial console output to %s", logPath))
+	}
+
+	s.console, err = newSerialConsole(socketPath, logPath)
+	if err != nil {
+		err := fmt.Errorf("Error creating serial console log: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	state.Put("serial_console", s.console)
+
+	return multistep.ActionContinue
+}
+
+func (s *stepConfigureSerial) Cleanup(multistep.StateBag) {
+	if s.console != nil {
+		s.console.close()
+	}
+
+	if s.dir != "" {
+		if err := os.RemoveAll(s.dir); err != nil {
+			log.Printf("failed to remove serial console directory: %v", err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package bhyve
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/multistep/commonsteps"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+// The volume label that cloud-init's NoCloud datasource looks for.
+const cloudInitLabel = "cidata"
+
+// useCloudInit returns whether a cloud-init seed has been configured.
+func (c *Config) useCloudInit() bool {
+	return c.CloudInitUserData != "" || c.CloudInitMetaData != "" ||
+		c.CloudInitNetworkConfig != ""
+}
+
+// cloudInitFiles maps the NoCloud file names to their configured values.
+func (c *Config) cloudInitFiles() map[string]string {
+	return map[string]string{
+		"meta-data":      c.CloudInitMetaData,
+		"network-config": c.CloudInitNetworkConfig,
+		"user-data":      c.CloudInitUserData,
+	}
+}
+
+// This step creates a NoCloud seed CD-ROM for cloud-init, which also holds
+// any cd_files and cd_content.  Each of the cloud_init options is either a
+// path to a file or the content itself, and is rendered with the same data
+// as the boot command so that it can refer to the HTTP server.
+//
+// Uses:
+//
+//	config    *config
+//	http_ip   string
+//	http_port int
+//	ui        packer.Ui
+//
+// Produces:
+//
+//	cd_path string - The path to the seed CD-ROM.
+type stepCreateCloudInit struct {
+	cd *commonsteps.StepCreateCD
+}
+
+func (step *stepCreateCloudInit) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	configCtx := config.ctx
+	configCtx.Data = &bootCommandTemplateData{
+		state.Get("http_ip").(string),
+		state.Get("http_port").(int),
+		config.VMName,
+	}
+
+	content := map[string]string{}
+	for name, value := range config.CDContent {
+		content[name] = value
+	}
+
+	for name, value := range config.cloudInitFiles() {
+		if value == "" {
+			continue
+		}
+
+		// A value naming an existing file is read from it, anything
+		// else is used as is.
+		if _, err := os.Stat(value); err == nil {
+			data, err := os.ReadFile(value)
+			if err != nil {
+				err := fmt.Errorf("Error reading cloud-init %s: %s", name, err)
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
+			value = string(data)
+		}
+
+		rendered, err := interpolate.Render(value, &configCtx)
+		if err != nil {
+			err := fmt.Errorf("Error interpolating cloud-init %s: %s", name, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		content[name] = rendered
+	}
+
+	// NoCloud requires meta-data, and uses the instance-id to decide
+	// whether this is a new instance, so derive it from the VM name to
+	// keep repeated builds consistent.
+	if meta_data, ok := content["meta-data"]; !ok {
+		content["meta-data"] = fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n",
+			config.VMName, config.VMName)
+	} else {
+		content["meta-data"] = withInstanceID(meta_data, config.VMName)
+	}
+
+	// A seed without user-data is not valid either.
+	if _, ok := content["user-data"]; !ok {
+		content["user-data"] = "#cloud-config\n"
+	}
+
+	ui.Say("Creating cloud-init seed CD-ROM")
+
+	step.cd = &commonsteps.StepCreateCD{
+		Files:   config.CDConfig.CDFiles,
+		Content: content,
+		Label:   cloudInitLabel,
+	}
+
+	return step.cd.Run(ctx, state)
+}
+
+func (step *stepCreateCloudInit) Cleanup(state multistep.StateBag) {
+	if step.cd != nil {
+		step.cd.Cleanup(state)
+	}
+}
+
+// The instance-id key, as YAML or JSON on one or more lines.
+var instanceIDPattern = regexp.MustCompile(`(?m)(^|[{,])\s*"?instance-id"?\s*:`)
+
+// withInstanceID adds an instance-id to meta_data if it does not have one.
+// meta-data may be YAML or JSON, so a JSON object gets the key added as its
+// first member.
+func withInstanceID(meta_data string, instance_id string) string {
+	if instanceIDPattern.MatchString(meta_data) {
+		return meta_data
+	}
+
+	trimmed := strings.TrimSpace(meta_data)
+	if strings.HasPrefix(trimmed, "{") {
+		rest := strings.TrimSpace(trimmed[1:])
+		separator := ", "
+		if strings.HasPrefix(rest, "}") {
+			separator = ""
+		}
+		return fmt.Sprintf("{\"instance-id\": %q%s%s\n", instance_id, separator, rest)
+	}
+
+	return fmt.Sprintf("instance-id: %s\n%s", instance_id, meta_data)
+}
@@ -0,0 +1,26 @@
+package bhyve
+
+import (
+	"testing"
+)
+
+func TestWithInstanceID(t *testing.T) {
+	tests := []struct {
+		name      string
+		meta_data string
+		want      string
+	}{
+		{"empty", "", "instance-id: vm\n"},
+		{"yaml", "local-hostname: host\n", "instance-id: vm\nlocal-hostname: host\n"},
+		{"yaml with id", "instance-id: other\n", "instance-id: other\n"},
+		{"json", `{"local-hostname": "host"}`, "{\"instance-id\": \"vm\", \"local-hostname\": \"host\"}\n"},
+		{"empty json", "{}", "{\"instance-id\": \"vm\"}\n"},
+		{"json with id", `{"instance-id": "other"}`, `{"instance-id": "other"}`},
+	}
+
+	for _, tt := range tests {
+		if got := withInstanceID(tt.meta_data, "vm"); got != tt.want {
+			t.Errorf("%s: withInstanceID(%q) = %q, want %q", tt.name, tt.meta_data, got, tt.want)
+		}
+	}
+}
@@ -19,9 +19,45 @@ func (step *stepCreateDisk) Run(ctx context.Context, state multistep.StateBag) m
 	ui := state.Get("ui").(packer.Ui)
 
 	disk_path := filepath.Join(config.OutputDir, config.DiskName)
+	if err := createDiskImage(ui, disk_path, config.DiskSize); err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	// With disk_image the downloaded file is written over the new disk
+	// instead of being attached as install media.
+	if config.DiskImage {
+		size, _ := parseDiskSize(config.DiskSize)
+		err := writeDiskImage(ui, state.Get("iso_path").(string), disk_path, size)
+		if err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+	state.Put("bhyve_disk_path", disk_path)
+
+	additional_paths := []string{}
+	for i, size := range config.AdditionalDiskSize {
+		disk_path := filepath.Join(config.OutputDir, config.additionalDiskName(i))
+		if err := createDiskImage(ui, disk_path, size); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		additional_paths = append(additional_paths, disk_path)
+	}
+	state.Put("bhyve_additional_disk_paths", additional_paths)
+
+	return multistep.ActionContinue
+}
+
+func (step *stepCreateDisk) Cleanup(state multistep.StateBag) {}
 
+func createDiskImage(ui packer.Ui, disk_path string, size string) error {
 	args := []string{
-		"-n", config.DiskSize,
+		"-n", size,
 		disk_path,
 	}
 
@@ -31,15 +67,8 @@ func (step *stepCreateDisk) Run(ctx context.Context, state multistep.StateBag) m
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
-		err := fmt.Errorf("Error creating image: %s", strings.TrimSpace(stderr.String()))
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return fmt.Errorf("Error creating image: %s", strings.TrimSpace(stderr.String()))
 	}
 
-	state.Put("bhyve_disk_path", disk_path)
-
-	return multistep.ActionContinue
+	return nil
 }
-
-func (step *stepCreateDisk) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,75 @@
+package bhyve
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+const efiVarsName = "efivars.fd"
+
+// The EFI variable template shipped with the bhyve UEFI firmware, which is
+// used when efi_vars is not set.
+const defaultEFIVars = "/usr/share/bhyve/firmware/BHYVE_VARS.fd"
+
+// This step creates a per-build EFI variable store from the efi_vars
+// template, or the firmware's own template for the default uefi firmware.
+// The store lives in the output directory, so the boot entries written by
+// the installer are shipped next to the disk.
+//
+// Uses:
+//
+//	config *config
+//	ui     packer.Ui
+//
+// Produces:
+//
+//	efi_vars_path string - The path to the EFI variable store.
+type stepCreateEFIVars struct{}
+
+func (step *stepCreateEFIVars) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	vars_path := filepath.Join(config.OutputDir, efiVarsName)
+
+	ui.Say(fmt.Sprintf("Creating EFI variable store %s", vars_path))
+
+	if err := copyFile(config.EFIVars, vars_path); err != nil {
+		err := fmt.Errorf("Error creating EFI variable store: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	state.Put("efi_vars_path", vars_path)
+
+	return multistep.ActionContinue
+}
+
+func (step *stepCreateEFIVars) Cleanup(state multistep.StateBag) {}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -4,9 +4,10 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"os"
+	"log"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
@@ -19,9 +20,63 @@ func (step *stepCreateSnapshot) Run(ctx context.Context, state multistep.StateBa
 	config := state.Get("config").(*Config)
 	ui := state.Get("ui").(packer.Ui)
 
+	// The boot disk is followed by any additional disks, only the boot
+	// disk can be sent incrementally.
+	zvol_paths := []string{fmt.Sprintf("%s/%s", config.DiskZPool, config.DiskName)}
+	file_paths := []string{filepath.Join(config.OutputDir, config.VMName)}
+	for i := range config.AdditionalDiskSize {
+		zvol_paths = append(zvol_paths,
+			fmt.Sprintf("%s/%s", config.DiskZPool, config.additionalDiskName(i)))
+		file_paths = append(file_paths, filepath.Join(config.OutputDir,
+			fmt.Sprintf("%s-%d", config.VMName, i+1)))
+	}
+
+	output_files := map[string][]string{}
+	for i, zvol_path := range zvol_paths {
+		for _, format := range config.OutputFormat {
+			var out_path string
+			var err error
+
+			switch format {
+			case "zfs":
+				out_path, err = sendZvol(ui, zvol_path, file_paths[i],
+					config.ZFSSendConfig.args(i == 0), config.OutputCompression)
+			case "raw":
+				out_path, err = exportZvol(ui, zvol_path,
+					file_paths[i]+".raw", config.OutputCompression)
+			case "qcow2", "vmdk":
+				out_path, err = convertZvol(ui, format, zvol_path,
+					file_paths[i]+"."+format, config.DiskCompression)
+			}
+			if err != nil {
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
+
+			output_files[format] = append(output_files[format], out_path)
+		}
+	}
+	state.Put("output_files", output_files)
+
+	// Record what zfs receive needs to accept the boot disk stream.
+	state.Put("zfs_base_snapshot", config.BaseSnapshot)
+	state.Put("zfs_stream_type", config.ZFSSendConfig.streamType())
+
+	return multistep.ActionContinue
+}
+
+func (step *stepCreateSnapshot) Cleanup(state multistep.StateBag) {}
+
+// sendZvol snapshots a zvol, sends the snapshot to file_path, and then
+// destroys the snapshot again.  Unless send_args has an incremental source
+// the stream is a full one, even if the zvol was cloned from
+// disk_source_snapshot, so that it can be received without the origin.
+//
+// The stream is compressed and digested by exportStream.
+func sendZvol(ui packer.Ui, zvol_path string, file_path string, send_args []string, compression string) (string, error) {
 	var stderr bytes.Buffer
-	snap_path := fmt.Sprintf("%s/%s@final", config.DiskZPool, config.DiskName)
-	file_path := filepath.Join(config.OutputDir, config.VMName)
+	snap_path := fmt.Sprintf("%s@final", zvol_path)
 
 	ui.Say(fmt.Sprintf("Creating ZFS snapshot %s", snap_path))
 	args := []string{
@@ -31,33 +86,38 @@ func (step *stepCreateSnapshot) Run(ctx context.Context, state multistep.StateBa
 	cmd := exec.Command("/usr/sbin/zfs", args...)
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
-		err := fmt.Errorf("Error creating snapshot: %s", strings.TrimSpace(stderr.String()))
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return "", fmt.Errorf("Error creating snapshot: %s", strings.TrimSpace(stderr.String()))
 	}
 
 	ui.Say(fmt.Sprintf("Sending snapshot %s to %s", snap_path, file_path))
-	args = []string{
-		"send",
-		snap_path,
-	}
-	outfile, err := os.Create(file_path)
+	args = []string{"send"}
+	args = append(args, send_args...)
+	args = append(args, snap_path)
+
+	// The estimate is only used for progress, so carry on without it.
+	size, err := estimateSend(args)
 	if err != nil {
-		err = fmt.Errorf("Error creating hard drive in output dir: %s", err)
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		log.Print(err.Error())
 	}
-	defer outfile.Close()
+
 	cmd = exec.Command("/usr/sbin/zfs", args...)
-	cmd.Stdout = outfile
+	stderr.Reset()
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		err := fmt.Errorf("Error sending snapshot: %s", strings.TrimSpace(stderr.String()))
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("Error sending snapshot: %s", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("Error sending snapshot: %s", err)
+	}
+	out_path, err := exportStream(ui, compression, stdout, size, file_path)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return "", err
+	}
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("Error sending snapshot: %s", strings.TrimSpace(stderr.String()))
 	}
 
 	ui.Say(fmt.Sprintf("Deleting ZFS snapshot %s", snap_path))
@@ -66,15 +126,64 @@ func (step *stepCreateSnapshot) Run(ctx context.Context, state multistep.StateBa
 		snap_path,
 	}
 	cmd = exec.Command("/usr/sbin/zfs", args...)
+	stderr.Reset()
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
-		err := fmt.Errorf("Error deleting snapshot: %s", strings.TrimSpace(stderr.String()))
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return "", fmt.Errorf("Error deleting snapshot: %s", strings.TrimSpace(stderr.String()))
 	}
 
-	return multistep.ActionContinue
+	return out_path, nil
 }
 
-func (step *stepCreateSnapshot) Cleanup(state multistep.StateBag) {}
+// exportZvol writes a raw image of a zvol to file_path, and returns the path
+// that was written.  The VM has been shut down, so the zvol is read directly.
+func exportZvol(ui packer.Ui, zvol_path string, file_path string, compression string) (string, error) {
+	size, err := zvolSize(zvol_path)
+	if err != nil {
+		return "", err
+	}
+
+	ui.Say(fmt.Sprintf("Exporting zvol %s to %s", zvol_path, file_path))
+
+	return exportRaw(ui, compression, fmt.Sprintf("/dev/zvol/rdsk/%s", zvol_path),
+		size, file_path)
+}
+
+// convertZvol writes a zvol to file_path as a qcow2 image or VMDK, and
+// returns the path that was written.
+func convertZvol(ui packer.Ui, format string, zvol_path string, file_path string, compress bool) (string, error) {
+	size, err := zvolSize(zvol_path)
+	if err != nil {
+		return "", err
+	}
+
+	return convertDisk(ui, format, fmt.Sprintf("/dev/zvol/rdsk/%s", zvol_path),
+		size, file_path, compress)
+}
+
+// estimateSend returns the size of the stream that zfs send with args will
+// produce, from the output of a dry run.
+func estimateSend(args []string) (int64, error) {
+	dry_run := []string{"send", "-nvP"}
+	dry_run = append(dry_run, args[1:]...)
+
+	cmd := exec.Command("/usr/sbin/zfs", dry_run...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("Error estimating send size: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	// The total is on a line of its own, for example "size	1234".  Some
+	// versions print it to stderr.
+	output := stdout.String() + stderr.String()
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "size" {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+
+	return 0, fmt.Errorf("Error estimating send size: no size in %q", output)
+}
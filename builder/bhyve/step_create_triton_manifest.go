@@ -0,0 +1,104 @@
+package bhyve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// The name of the image manifest written to the output directory.
+const tritonManifestName = "manifest.json"
+
+// This step writes an imgadm manifest for the boot disk stream, so that it
+// can be installed on SmartOS or uploaded to a Triton IMGAPI.
+//
+// Uses:
+//
+//	config       *config
+//	output_files map[string][]string
+//	ui           packer.Ui
+//
+// Produces:
+//
+//	triton_image_uuid string - The UUID of the image in the manifest.
+type stepCreateTritonManifest struct{}
+
+func (step *stepCreateTritonManifest) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+	output_files := state.Get("output_files").(map[string][]string)
+
+	manifest_path := filepath.Join(config.OutputDir, tritonManifestName)
+	ui.Say(fmt.Sprintf("Writing image manifest %s", manifest_path))
+
+	if err := writeTritonManifest(config, output_files["zfs"][0], manifest_path); err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	state.Put("triton_image_uuid", config.TritonImage.UUID)
+
+	return multistep.ActionContinue
+}
+
+func (step *stepCreateTritonManifest) Cleanup(state multistep.StateBag) {}
+
+// writeTritonManifest writes the manifest for the stream at stream_path to
+// manifest_path.
+func writeTritonManifest(config *Config, stream_path string, manifest_path string) error {
+	info, err := os.Stat(stream_path)
+	if err != nil {
+		return fmt.Errorf("Error opening %s: %s", stream_path, err)
+	}
+
+	// The digest was written with the stream, so there is no need to
+	// read the stream again.
+	digest, err := os.ReadFile(stream_path + ".sha1")
+	if err != nil {
+		return fmt.Errorf("Error reading digest: %s", err)
+	}
+	fields := strings.Fields(string(digest))
+	if len(fields) == 0 {
+		return fmt.Errorf("Error reading digest: %s.sha1 is empty", stream_path)
+	}
+
+	compression, err := tritonCompression(config.OutputCompression)
+	if err != nil {
+		return err
+	}
+
+	// A zvol cloned from disk_source_snapshot without disk_size keeps the
+	// size of its origin.
+	var size int64
+	if config.DiskSize != "" {
+		size, err = parseDiskSize(config.DiskSize)
+	} else {
+		size, err = zvolSize(fmt.Sprintf("%s/%s", config.DiskZPool, config.DiskName))
+	}
+	if err != nil {
+		return fmt.Errorf("Error getting disk size: %s", err)
+	}
+
+	manifest := config.TritonImage.manifest(tritonFile{
+		SHA1:        fields[0],
+		Size:        info.Size(),
+		Compression: compression,
+	}, int(size/(1024*1024)), time.Now())
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error encoding manifest: %s", err)
+	}
+	if err := os.WriteFile(manifest_path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("Error writing manifest: %s", err)
+	}
+
+	return nil
+}
@@ -14,61 +14,85 @@ import (
 )
 
 type stepCreateVNIC struct {
-	name string
+	vnics []string
 }
 
 func (step *stepCreateVNIC) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	config := state.Get("config").(*Config)
 	ui := state.Get("ui").(packer.Ui)
 
+	err := step.createVNIC(ui, config.VNICName, config.VNICLink, config.MACAddress)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	for _, nic := range config.AdditionalNICs {
+		err := step.createVNIC(ui, nic.Name, nic.Link, nic.MACAddress)
+		if err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	return multistep.ActionContinue
+}
+
+func (step *stepCreateVNIC) createVNIC(ui packer.Ui, name string, link string, mac string) error {
 	args := []string{
 		"create-vnic",
 		"-t",
-		"-l", config.VNICLink,
-		config.VNICName,
+		"-l", link,
 	}
+	if mac != "" {
+		args = append(args, "-m", mac)
+	}
+	args = append(args, name)
 
-	ui.Say(fmt.Sprintf("Creating VNIC %s on link %s", config.VNICName, config.VNICLink))
+	ui.Say(fmt.Sprintf("Creating VNIC %s on link %s", name, link))
 
 	cmd := exec.Command("/usr/sbin/dladm", args...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
-		err := fmt.Errorf("Error creating VNIC: %s", strings.TrimSpace(stderr.String()))
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return fmt.Errorf("Error creating VNIC: %s", strings.TrimSpace(stderr.String()))
 	}
 
-	return multistep.ActionContinue
+	step.vnics = append(step.vnics, name)
+
+	return nil
 }
 
 func (step *stepCreateVNIC) Cleanup(state multistep.StateBag) {
-	config := state.Get("config").(*Config)
 	ui := state.Get("ui").(packer.Ui)
 
-	args := []string{
-		"delete-vnic",
-		config.VNICName,
-	}
+	for _, name := range step.vnics {
+		args := []string{
+			"delete-vnic",
+			name,
+		}
+
+		ui.Say(fmt.Sprintf("Deleting VNIC %s", name))
 
-	ui.Say(fmt.Sprintf("Deleting VNIC %s from link %s", config.VNICName, config.VNICLink))
-
-	// Despite bhyvectl --destroy running before us, this will often fail
-	// with EBUSY for a few seconds afterwards, so we retry a few times.
-	var retries = 4
-	for i := 1; i <= retries; i++ {
-		cmd := exec.Command("/usr/sbin/dladm", args...)
-		var stderr bytes.Buffer
-		cmd.Stderr = &stderr
-		if err := cmd.Run(); err != nil {
-			if i == retries {
-				log.Printf("Error deleting VNIC: %s", strings.TrimSpace(stderr.String()))
-				break
+		// Despite bhyvectl --destroy running before us, this will often
+		// fail with EBUSY for a few seconds afterwards, so we retry a few
+		// times.
+		var retries = 4
+		for i := 1; i <= retries; i++ {
+			cmd := exec.Command("/usr/sbin/dladm", args...)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err != nil {
+				if i == retries {
+					log.Printf("Error deleting VNIC: %s", strings.TrimSpace(stderr.String()))
+					break
+				}
+				time.Sleep(5 * time.Second)
+				continue
 			}
-			time.Sleep(5 * time.Second)
-			continue
+			break
 		}
-		break
 	}
 }
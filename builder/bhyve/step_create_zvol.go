@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,20 +14,70 @@ import (
 	"github.com/hashicorp/packer-plugin-sdk/packer"
 )
 
-type stepCreateZvol struct{}
+type stepCreateZvol struct {
+	zvols []string
+}
 
 func (step *stepCreateZvol) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	config := state.Get("config").(*Config)
 	ui := state.Get("ui").(packer.Ui)
 
 	zvol_path := fmt.Sprintf("%s/%s", config.DiskZPool, config.DiskName)
-	rdsk_path := fmt.Sprintf("/dev/zvol/rdsk/%s", zvol_path)
+	var err error
+	if config.DiskSourceSnapshot != "" {
+		err = step.cloneZvol(ui, config, config.DiskSourceSnapshot, zvol_path, config.DiskSize)
+	} else {
+		err = step.createZvol(ui, config, zvol_path, config.DiskSize)
+	}
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	disk_path := fmt.Sprintf("/dev/zvol/rdsk/%s", zvol_path)
+
+	// With disk_image the downloaded file is written over the new disk
+	// instead of being attached as install media.
+	if config.DiskImage {
+		size, _ := parseDiskSize(config.DiskSize)
+		err := writeDiskImage(ui, state.Get("iso_path").(string), disk_path, size)
+		if err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+	state.Put("bhyve_disk_path", disk_path)
+
+	additional_paths := []string{}
+	for i, size := range config.AdditionalDiskSize {
+		zvol_path := fmt.Sprintf("%s/%s", config.DiskZPool, config.additionalDiskName(i))
+		if err := step.createZvol(ui, config, zvol_path, size); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		additional_paths = append(additional_paths,
+			fmt.Sprintf("/dev/zvol/rdsk/%s", zvol_path))
+	}
+	state.Put("bhyve_additional_disk_paths", additional_paths)
+
+	return multistep.ActionContinue
+}
+
+// createZvol creates a zvol of size with the disk_zvol_* properties, and any
+// missing parent datasets.
+func (step *stepCreateZvol) createZvol(ui packer.Ui, config *Config, zvol_path string, size string) error {
+	if err := replaceDataset(ui, zvol_path, config.PackerForce); err != nil {
+		return err
+	}
 
-	args := []string{
-		"create",
-		"-V", config.DiskSize,
-		zvol_path,
+	args := []string{"create", "-p"}
+	if config.ZvolConfig.Sparse {
+		args = append(args, "-s")
 	}
+	args = append(args, config.ZvolConfig.options(false)...)
+	args = append(args, "-V", size, zvol_path)
 
 	ui.Say(fmt.Sprintf("Creating ZFS zvol %s", zvol_path))
 
@@ -34,45 +85,126 @@ func (step *stepCreateZvol) Run(ctx context.Context, state multistep.StateBag) m
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
-		err := fmt.Errorf("Error creating zvol: %s", strings.TrimSpace(stderr.String()))
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return fmt.Errorf("Error creating zvol: %s", strings.TrimSpace(stderr.String()))
 	}
 
-	state.Put("bhyve_disk_path", rdsk_path)
+	step.zvols = append(step.zvols, zvol_path)
 
-	return multistep.ActionContinue
+	return nil
+}
+
+// cloneZvol clones the zvol from snapshot, and grows it to size if that is
+// set.  The clone depends on its origin until it is destroyed in Cleanup,
+// which leaves the origin untouched.  A clone is always sparse.
+func (step *stepCreateZvol) cloneZvol(ui packer.Ui, config *Config, snapshot string, zvol_path string, size string) error {
+	if err := replaceDataset(ui, zvol_path, config.PackerForce); err != nil {
+		return err
+	}
+
+	args := []string{"clone", "-p"}
+	args = append(args, config.ZvolConfig.options(true)...)
+	args = append(args, snapshot, zvol_path)
+
+	ui.Say(fmt.Sprintf("Cloning ZFS snapshot %s to %s", snapshot, zvol_path))
+
+	cmd := exec.Command("/usr/sbin/zfs", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error cloning zvol: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	step.zvols = append(step.zvols, zvol_path)
+
+	if size == "" {
+		return nil
+	}
+
+	want, _ := parseDiskSize(size)
+	have, err := zvolSize(zvol_path)
+	if err != nil {
+		return err
+	}
+	if want < have {
+		return fmt.Errorf("disk_size %s is smaller than disk_source_snapshot (%d bytes)", size, have)
+	}
+	if want == have {
+		return nil
+	}
+
+	ui.Say(fmt.Sprintf("Growing ZFS zvol %s to %s", zvol_path, size))
+
+	cmd = exec.Command("/usr/sbin/zfs", "set", fmt.Sprintf("volsize=%s", size), zvol_path)
+	stderr.Reset()
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error growing zvol: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// zvolSize returns the volsize of a zvol in bytes.
+func zvolSize(zvol_path string) (int64, error) {
+	cmd := exec.Command("/usr/sbin/zfs", "get", "-Hpo", "value", "volsize", zvol_path)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("Error getting zvol size: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(stdout.String()), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Error getting zvol size: %s", err)
+	}
+
+	return size, nil
 }
 
 func (step *stepCreateZvol) Cleanup(state multistep.StateBag) {
-	config := state.Get("config").(*Config)
 	ui := state.Get("ui").(packer.Ui)
 
-	zvol_path := fmt.Sprintf("%s/%s", config.DiskZPool, config.DiskName)
-
-	args := []string{
-		"destroy",
-		zvol_path,
+	// Zvols renamed by stepPublishDataset are no longer ours to destroy.
+	published := map[string]bool{}
+	if zvols, ok := state.GetOk("published_zvols"); ok {
+		for _, zvol_path := range zvols.([]string) {
+			published[zvol_path] = true
+		}
 	}
 
-	ui.Say(fmt.Sprintf("Destroying ZFS zvol %s", zvol_path))
+	for _, zvol_path := range step.zvols {
+		if published[zvol_path] {
+			continue
+		}
 
-	// Despite bhyvectl --destroy running before us, this will often fail
-	// with EBUSY for a few seconds afterwards, so we retry a few times.
-	var retries = 4
-	for i := 1; i <= retries; i++ {
-		cmd := exec.Command("/usr/sbin/zfs", args...)
-		var stderr bytes.Buffer
-		cmd.Stderr = &stderr
-		if err := cmd.Run(); err != nil {
-			if i == retries {
-				log.Printf("Error destroying zvol: %s", strings.TrimSpace(stderr.String()))
-				break
+		// Also destroy any snapshot left behind by a failed
+		// stepCreateSnapshot, which would otherwise keep the zvol.
+		args := []string{
+			"destroy",
+			"-r",
+			zvol_path,
+		}
+
+		ui.Say(fmt.Sprintf("Destroying ZFS zvol %s", zvol_path))
+
+		// Despite bhyvectl --destroy running before us, this will often
+		// fail with EBUSY for a few seconds afterwards, so we retry a few
+		// times.
+		var retries = 4
+		for i := 1; i <= retries; i++ {
+			cmd := exec.Command("/usr/sbin/zfs", args...)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err != nil {
+				if i == retries {
+					log.Printf("Error destroying zvol: %s", strings.TrimSpace(stderr.String()))
+					break
+				}
+				time.Sleep(5 * time.Second)
+				continue
 			}
-			time.Sleep(5 * time.Second)
-			continue
+			break
 		}
-		break
 	}
 }
@@ -0,0 +1,89 @@
+package bhyve
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// This step exports file-backed disks in the same way that
+// stepCreateSnapshot exports zvols, converting them to each output_format
+// and writing their digests.  The raw disk is compressed if
+// output_compression is set, or removed if raw is not one of the formats.
+//
+// Uses:
+//
+//	bhyve_disk_path             string
+//	bhyve_additional_disk_paths []string
+//	config                      *config
+//	ui                          packer.Ui
+//
+// Produces:
+//
+//	output_files map[string][]string - The exported disks.
+type stepExportDisk struct{}
+
+func (step *stepExportDisk) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	disk_paths := []string{state.Get("bhyve_disk_path").(string)}
+	additional_paths, _ := state.Get("bhyve_additional_disk_paths").([]string)
+	disk_paths = append(disk_paths, additional_paths...)
+
+	output_files := map[string][]string{}
+	for _, disk_path := range disk_paths {
+		if err := exportDiskFile(ui, config, disk_path, output_files); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+	state.Put("output_files", output_files)
+
+	return multistep.ActionContinue
+}
+
+func (step *stepExportDisk) Cleanup(state multistep.StateBag) {}
+
+// exportDiskFile writes the disk at disk_path in each output_format, adding
+// the files written to output_files.
+func exportDiskFile(ui packer.Ui, config *Config, disk_path string, output_files map[string][]string) error {
+	info, err := os.Stat(disk_path)
+	if err != nil {
+		return fmt.Errorf("Error opening disk: %s", err)
+	}
+
+	keep_raw := false
+	for _, format := range config.OutputFormat {
+		if format == "raw" {
+			keep_raw = true
+			continue
+		}
+
+		out_path, err := convertDisk(ui, format, disk_path, info.Size(),
+			disk_path+"."+format, config.DiskCompression)
+		if err != nil {
+			return err
+		}
+		output_files[format] = append(output_files[format], out_path)
+	}
+
+	if !keep_raw {
+		if err := os.Remove(disk_path); err != nil {
+			return fmt.Errorf("Error removing raw disk: %s", err)
+		}
+		return nil
+	}
+
+	out_path, err := exportFile(ui, config.OutputCompression, disk_path)
+	if err != nil {
+		return err
+	}
+	output_files["raw"] = append(output_files["raw"], out_path)
+
+	return nil
+}
@@ -2,8 +2,10 @@ package bhyve
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
@@ -37,9 +39,18 @@ func (stepPrepareOutputDir) Cleanup(state multistep.StateBag) {
 		config := state.Get("config").(*Config)
 		ui := state.Get("ui").(packer.Ui)
 
-		ui.Say("Deleting output directory...")
+		// Keep the serial console log around, it is usually the only
+		// record of why the guest failed to install.
+		keep := ""
+		if config.SerialLog {
+			keep = filepath.Join(config.OutputDir, serialLogName)
+			ui.Say(fmt.Sprintf("Deleting output directory, keeping %s...", keep))
+		} else {
+			ui.Say("Deleting output directory...")
+		}
+
 		for i := 0; i < 5; i++ {
-			err := os.RemoveAll(config.OutputDir)
+			err := removeOutputDir(config.OutputDir, keep)
 			if err == nil {
 				break
 			}
@@ -49,3 +60,28 @@ func (stepPrepareOutputDir) Cleanup(state multistep.StateBag) {
 		}
 	}
 }
+
+// removeOutputDir removes the output directory, or if keep is set, all of
+// its contents other than the keep file.
+func removeOutputDir(dir string, keep string) error {
+	if keep == "" {
+		return os.RemoveAll(dir)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if path == keep {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package bhyve
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/tritondatacenter/packer-plugin-bhyve/version"
+)
+
+// This step keeps the zvols as datasets named after publish_dataset, with a
+// @final snapshot and user properties describing the build, rather than
+// leaving stepCreateZvol to destroy them.  A zvol is renamed into place if
+// it is in the same pool and was not cloned, otherwise it is copied with
+// zfs send and zfs receive.
+//
+// Uses:
+//
+//	config *config
+//	ui     packer.Ui
+//
+// Produces:
+//
+//	published_datasets []string - The datasets that were published.
+//	published_zvols    []string - The zvols that were renamed, and so
+//	                              must not be destroyed by stepCreateZvol.
+type stepPublishDataset struct {
+	datasets []string
+	zvols    []string
+}
+
+func (step *stepPublishDataset) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+
+	// Additional disks are published alongside the boot disk, with the
+	// same suffix as their zfs send streams.
+	zvol_paths := []string{fmt.Sprintf("%s/%s", config.DiskZPool, config.DiskName)}
+	datasets := []string{config.PublishDataset}
+	for i := range config.AdditionalDiskSize {
+		zvol_paths = append(zvol_paths,
+			fmt.Sprintf("%s/%s", config.DiskZPool, config.additionalDiskName(i)))
+		datasets = append(datasets, fmt.Sprintf("%s-%d", config.PublishDataset, i+1))
+	}
+
+	properties := map[string]string{
+		"packer:build_name":     config.PackerBuildName,
+		"packer:build_time":     time.Now().UTC().Format(time.RFC3339),
+		"packer:iso_checksum":   config.ISOChecksum,
+		"packer:plugin_version": version.PluginVersion.String(),
+	}
+
+	for i, zvol_path := range zvol_paths {
+		rename := zfsPool(zvol_path) == zfsPool(datasets[i]) && config.DiskSourceSnapshot == ""
+		err := step.publish(ui, zvol_path, datasets[i], rename, config.PackerForce, properties)
+		state.Put("published_zvols", step.zvols)
+		if err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+	state.Put("published_datasets", step.datasets)
+
+	return multistep.ActionContinue
+}
+
+// Cleanup destroys the datasets if the build failed, so that a partly
+// published build is not mistaken for a finished one.
+func (step *stepPublishDataset) Cleanup(state multistep.StateBag) {
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if !cancelled && !halted {
+		return
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	for _, dataset := range step.datasets {
+		ui.Say(fmt.Sprintf("Destroying ZFS dataset %s", dataset))
+		if err := destroyDataset(dataset); err != nil {
+			log.Print(err.Error())
+		}
+	}
+}
+
+// publish renames or copies zvol_path to dataset, and sets its properties.
+func (step *stepPublishDataset) publish(ui packer.Ui, zvol_path string, dataset string, rename bool, force bool, properties map[string]string) error {
+	if err := replaceDataset(ui, dataset, force); err != nil {
+		return err
+	}
+
+	if rename {
+		if err := renameZvol(ui, zvol_path, dataset); err != nil {
+			return err
+		}
+		step.zvols = append(step.zvols, zvol_path)
+		step.datasets = append(step.datasets, dataset)
+
+		if err := snapshotDataset(ui, dataset); err != nil {
+			return err
+		}
+	} else {
+		if err := receiveZvol(ui, zvol_path, dataset); err != nil {
+			return err
+		}
+		step.datasets = append(step.datasets, dataset)
+	}
+
+	return setProperties(dataset, properties)
+}
+
+// replaceDataset fails if dataset exists, for example from an earlier build,
+// unless force is set, in which case it is destroyed.
+func replaceDataset(ui packer.Ui, dataset string, force bool) error {
+	exists, err := zfsExists(dataset)
+	if err != nil || !exists {
+		return err
+	}
+	if !force {
+		return fmt.Errorf("Dataset %s already exists, use -force to replace it", dataset)
+	}
+
+	ui.Say(fmt.Sprintf("Destroying existing ZFS dataset %s", dataset))
+	return destroyDataset(dataset)
+}
+
+// renameZvol moves a zvol to dataset, creating any missing parents.
+func renameZvol(ui packer.Ui, zvol_path string, dataset string) error {
+	ui.Say(fmt.Sprintf("Renaming ZFS zvol %s to %s", zvol_path, dataset))
+
+	cmd := exec.Command("/usr/sbin/zfs", "rename", "-p", zvol_path, dataset)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error renaming zvol: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// snapshotDataset creates dataset@final, matching the snapshot that is sent
+// to the output directory.
+func snapshotDataset(ui packer.Ui, dataset string) error {
+	snap_path := fmt.Sprintf("%s@final", dataset)
+
+	ui.Say(fmt.Sprintf("Creating ZFS snapshot %s", snap_path))
+
+	cmd := exec.Command("/usr/sbin/zfs", "snapshot", snap_path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error creating snapshot: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// receiveZvol copies a zvol to dataset with zfs send and zfs receive, which
+// leaves dataset@final behind.  The snapshot of the zvol itself is destroyed
+// with the zvol by stepCreateZvol.
+func receiveZvol(ui packer.Ui, zvol_path string, dataset string) error {
+	if err := snapshotDataset(ui, zvol_path); err != nil {
+		return err
+	}
+
+	// zfs receive does not create missing parents.
+	var stderr bytes.Buffer
+	cmd := exec.Command("/usr/sbin/zfs", "create", "-p", path.Dir(dataset))
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error creating dataset: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	snap_path := fmt.Sprintf("%s@final", zvol_path)
+	args := []string{"send", snap_path}
+
+	ui.Say(fmt.Sprintf("Sending snapshot %s to %s", snap_path, dataset))
+
+	// The estimate is only used for progress, so carry on without it.
+	size, err := estimateSend(args)
+	if err != nil {
+		log.Print(err.Error())
+	}
+
+	send := exec.Command("/usr/sbin/zfs", args...)
+	var send_stderr bytes.Buffer
+	send.Stderr = &send_stderr
+	stdout, err := send.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("Error sending snapshot: %s", err)
+	}
+
+	receive := exec.Command("/usr/sbin/zfs", "receive", dataset)
+	stream := ui.TrackProgress(dataset, 0, size, stdout)
+	defer stream.Close()
+	receive.Stdin = stream
+	stderr.Reset()
+	receive.Stderr = &stderr
+
+	if err := send.Start(); err != nil {
+		return fmt.Errorf("Error sending snapshot: %s", err)
+	}
+	if err := receive.Run(); err != nil {
+		send.Process.Kill()
+		send.Wait()
+		return fmt.Errorf("Error receiving snapshot: %s", strings.TrimSpace(stderr.String()))
+	}
+	if err := send.Wait(); err != nil {
+		return fmt.Errorf("Error sending snapshot: %s", strings.TrimSpace(send_stderr.String()))
+	}
+
+	return nil
+}
+
+// setProperties sets the ZFS user properties of dataset, skipping any that
+// are empty.
+func setProperties(dataset string, properties map[string]string) error {
+	args := []string{"set"}
+	for name, value := range properties {
+		if value != "" {
+			args = append(args, fmt.Sprintf("%s=%s", name, value))
+		}
+	}
+	args = append(args, dataset)
+
+	cmd := exec.Command("/usr/sbin/zfs", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error setting properties: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// zfsPool returns the pool that a dataset is in.
+func zfsPool(dataset string) string {
+	return strings.SplitN(dataset, "/", 2)[0]
+}
+
+// zfsExists returns whether a dataset exists.
+func zfsExists(dataset string) (bool, error) {
+	cmd := exec.Command("/usr/sbin/zfs", "list", "-H", "-o", "name", dataset)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stderr.String(), "does not exist") {
+			return false, nil
+		}
+		return false, fmt.Errorf("Error checking dataset: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return true, nil
+}
+
+// destroyDataset destroys a dataset and its snapshots.
+func destroyDataset(dataset string) error {
+	cmd := exec.Command("/usr/sbin/zfs", "destroy", "-r", dataset)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error destroying dataset: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
@@ -39,7 +39,10 @@ func (s *stepShutdown) Run(ctx context.Context, state multistep.StateBag) multis
 		cancelCh := make(chan struct{}, 1)
 		go func() {
 			defer close(cancelCh)
-			<-time.After(s.ShutdownTimeout)
+			select {
+			case <-time.After(s.ShutdownTimeout):
+			case <-ctx.Done():
+			}
 		}()
 		ui.Say("Waiting for shutdown...")
 		if ok := driver.WaitForShutdown(cancelCh); ok {
@@ -69,7 +72,10 @@ func (s *stepShutdown) Run(ctx context.Context, state multistep.StateBag) multis
 		cancelCh := make(chan struct{}, 1)
 		go func() {
 			defer close(cancelCh)
-			<-time.After(s.ShutdownTimeout)
+			select {
+			case <-time.After(s.ShutdownTimeout):
+			case <-ctx.Done():
+			}
 		}()
 
 		log.Printf("Waiting max %s for shutdown to complete", s.ShutdownTimeout)
@@ -81,7 +87,7 @@ func (s *stepShutdown) Run(ctx context.Context, state multistep.StateBag) multis
 		}
 	} else {
 		ui.Say("Halting the virtual machine...")
-		if err := driver.Stop(); err != nil {
+		if err := driver.Stop(ctx); err != nil {
 			err := fmt.Errorf("Error stopping VM: %s", err)
 			state.Put("error", err)
 			ui.Error(err.Error())
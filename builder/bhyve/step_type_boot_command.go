@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
@@ -26,12 +28,67 @@ type bootCommandTemplateData struct {
 	Name     string
 }
 
-// This step "types" the boot command into the VM over VNC.
+// Default time to wait for a boot step pattern to appear on the console.
+const defaultBootStepTimeout = 5 * time.Minute
+
+// bootStep is a single entry of boot_steps, which has the form:
+//
+//	[command, description, wait_for, timeout]
+//
+// where all but the command are optional.  If wait_for is set, it is a
+// regular expression that must appear on the serial console before the
+// command is typed.
+type bootStep struct {
+	command     string
+	description string
+	waitFor     *regexp.Regexp
+	timeout     time.Duration
+}
+
+func parseBootStep(step []string) (*bootStep, error) {
+	if len(step) > 4 {
+		return nil, fmt.Errorf("boot step has %d elements, maximum is 4", len(step))
+	}
+
+	bs := &bootStep{
+		timeout: defaultBootStepTimeout,
+	}
+
+	if len(step) >= 1 {
+		bs.command = step[0]
+	}
+
+	if len(step) >= 2 {
+		bs.description = step[1]
+	}
+
+	if len(step) >= 3 && step[2] != "" {
+		re, err := regexp.Compile(step[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid boot step pattern %q: %s", step[2], err)
+		}
+		bs.waitFor = re
+	}
+
+	if len(step) >= 4 && step[3] != "" {
+		timeout, err := time.ParseDuration(step[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid boot step timeout %q: %s", step[3], err)
+		}
+		bs.timeout = timeout
+	}
+
+	return bs, nil
+}
+
+// This step "types" the boot command into the VM over VNC or the serial
+// console.
 //
 // Uses:
 //
 //	config *config
 //	http_port int
+//	serial_console *serialConsole
 //	ui     packer.Ui
 //	vnc_port int
 //
@@ -59,11 +116,8 @@ func typeBootCommands(ctx context.Context, state multistep.StateBag, bootSteps [
 	debug := state.Get("debug").(bool)
 	httpPort := state.Get("http_port").(int)
 	ui := state.Get("ui").(packer.Ui)
-	vncPort := state.Get("vnc_port").(int)
-	vncIP := config.VNCBindAddress
-	vncPassword := state.Get("vnc_password")
 
-	if config.VNCConfig.DisableVNC {
+	if config.BootTransport == "vnc" && config.VNCConfig.DisableVNC {
 		log.Println("Skipping boot command step...")
 		return multistep.ActionContinue
 	}
@@ -84,36 +138,62 @@ func typeBootCommands(ctx context.Context, state multistep.StateBag, bootSteps [
 		pauseFn = state.Get("pauseFn").(multistep.DebugPauseFn)
 	}
 
-	// Connect to VNC
-	ui.Say(fmt.Sprintf("Connecting to VM via VNC (%s:%d)", vncIP, vncPort))
+	var d bootcommand.BCDriver
+	var transport string
 
-	nc, err := net.Dial("tcp", fmt.Sprintf("%s:%d", vncIP, vncPort))
-	if err != nil {
-		err := fmt.Errorf("Error connecting to VNC: %s", err)
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
-	}
-	defer nc.Close()
+	switch config.BootTransport {
+	case "serial":
+		console := state.Get("serial_console").(*serialConsole)
 
-	var auth []vnc.ClientAuth
+		ui.Say("Connecting to VM via serial console")
+		if err := console.waitConnected(ctx, time.Minute); err != nil {
+			err := fmt.Errorf("Error connecting to serial console: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
 
-	if vncPassword != nil && len(vncPassword.(string)) > 0 {
-		auth = []vnc.ClientAuth{&vnc.PasswordAuth{Password: vncPassword.(string)}}
-	} else {
-		auth = []vnc.ClientAuth{new(vnc.ClientAuthNone)}
-	}
+		d = newSerialBootDriver(console, config.VNCConfig.BootKeyInterval)
+		transport = "serial console"
+	default:
+		vncPort := state.Get("vnc_port").(int)
+		vncIP := config.VNCBindAddress
+		vncPassword := state.Get("vnc_password")
 
-	c, err := vnc.Client(nc, &vnc.ClientConfig{Auth: auth, Exclusive: false})
-	if err != nil {
-		err := fmt.Errorf("Error handshaking with VNC: %s", err)
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
-	}
-	defer c.Close()
+		// Connect to VNC
+		ui.Say(fmt.Sprintf("Connecting to VM via VNC (%s:%d)", vncIP, vncPort))
+
+		nc, err := net.Dial("tcp", net.JoinHostPort(vncIP, strconv.Itoa(vncPort)))
+		if err != nil {
+			err := fmt.Errorf("Error connecting to VNC: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		defer nc.Close()
 
-	log.Printf("Connected to VNC desktop: %s", c.DesktopName)
+		var auth []vnc.ClientAuth
+
+		if vncPassword != nil && len(vncPassword.(string)) > 0 {
+			auth = []vnc.ClientAuth{&vnc.PasswordAuth{Password: vncPassword.(string)}}
+		} else {
+			auth = []vnc.ClientAuth{new(vnc.ClientAuthNone)}
+		}
+
+		c, err := vnc.Client(nc, &vnc.ClientConfig{Auth: auth, Exclusive: false})
+		if err != nil {
+			err := fmt.Errorf("Error handshaking with VNC: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		defer c.Close()
+
+		log.Printf("Connected to VNC desktop: %s", c.DesktopName)
+
+		d = bootcommand.NewVNCDriver(c, config.VNCConfig.BootKeyInterval)
+		transport = "VNC"
+	}
 
 	hostIP := state.Get("http_ip").(string)
 	configCtx := config.ctx
@@ -123,28 +203,41 @@ func typeBootCommands(ctx context.Context, state multistep.StateBag, bootSteps [
 		config.VMName,
 	}
 
-	d := bootcommand.NewVNCDriver(c, config.VNCConfig.BootKeyInterval)
+	ui.Say(fmt.Sprintf("Typing the boot commands over %s...", transport))
 
-	ui.Say("Typing the boot commands over VNC...")
-
-	for _, step := range bootSteps {
-		if len(step) == 0 {
+	for _, rawStep := range bootSteps {
+		if len(rawStep) == 0 {
 			continue
 		}
 
-		var description string
+		step, err := parseBootStep(rawStep)
+		if err != nil {
+			err := fmt.Errorf("Error parsing boot step: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+
+		description := step.description
 
-		if len(step) >= 2 {
-			description = step[1]
-		} else {
-			description = ""
+		if step.waitFor != nil {
+			console := state.Get("serial_console").(*serialConsole)
+
+			ui.Say(fmt.Sprintf("Waiting up to %s for %q on serial console...",
+				step.timeout, step.waitFor.String()))
+			if err := console.expect(ctx, step.waitFor, step.timeout); err != nil {
+				err := fmt.Errorf("Error waiting for boot step: %s", err)
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
 		}
 
 		if len(description) > 0 {
 			ui.Say(fmt.Sprintf("Typing boot command for: %s", description))
 		}
 
-		command, err := interpolate.Render(step[0], &configCtx)
+		command, err := interpolate.Render(step.command, &configCtx)
 
 		if err != nil {
 			err := fmt.Errorf("Error preparing boot command: %s", err)
@@ -0,0 +1,54 @@
+package bhyve
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBootStep(t *testing.T) {
+	tests := []struct {
+		step        []string
+		command     string
+		description string
+		waitFor     string
+		timeout     time.Duration
+		err         bool
+	}{
+		{[]string{}, "", "", "", defaultBootStepTimeout, false},
+		{[]string{"<enter>"}, "<enter>", "", "", defaultBootStepTimeout, false},
+		{[]string{"<enter>", "Boot"}, "<enter>", "Boot", "", defaultBootStepTimeout, false},
+		{[]string{"root<enter>", "Log in", "login: $"}, "root<enter>", "Log in", "login: $",
+			defaultBootStepTimeout, false},
+		{[]string{"root<enter>", "Log in", "login: $", "10m"}, "root<enter>", "Log in", "login: $",
+			10 * time.Minute, false},
+		{[]string{"root<enter>", "Log in", "", "10m"}, "root<enter>", "Log in", "",
+			10 * time.Minute, false},
+		{[]string{"root<enter>", "Log in", "login: $", ""}, "root<enter>", "Log in", "login: $",
+			defaultBootStepTimeout, false},
+		{[]string{"root<enter>", "Log in", "login: ("}, "", "", "", 0, true},
+		{[]string{"root<enter>", "Log in", "login: $", "ten"}, "", "", "", 0, true},
+		{[]string{"root<enter>", "Log in", "login: $", "10m", "extra"}, "", "", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		bs, err := parseBootStep(tt.step)
+		if (err != nil) != tt.err {
+			t.Errorf("parseBootStep(%q) error = %v, want error %v", tt.step, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		waitFor := ""
+		if bs.waitFor != nil {
+			waitFor = bs.waitFor.String()
+		}
+		if bs.command != tt.command || bs.description != tt.description ||
+			waitFor != tt.waitFor || bs.timeout != tt.timeout {
+			t.Errorf("parseBootStep(%q) = %q, %q, %q, %s, want %q, %q, %q, %s", tt.step,
+				bs.command, bs.description, waitFor, bs.timeout,
+				tt.command, tt.description, tt.waitFor, tt.timeout)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"os/exec"
 	"strings"
 	"time"
@@ -25,14 +26,21 @@ type stepWaitGuestAddress struct {
 
 func (s *stepWaitGuestAddress) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	config := state.Get("config").(*Config)
+	driver := state.Get("driver").(Driver)
 	ui := state.Get("ui").(packer.Ui)
 
 	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
-	// Firstly get the VNIC MAC address, as this should be immediately
-	// available and not change.
+	// Firstly get the MAC address of the primary NIC, either as configured
+	// or from the VNIC, as this should be immediately available and not
+	// change.
 	vnic_mac := get_vnic_mac(config.VNICName)
+	if config.MACAddress != "" {
+		if mac, err := net.ParseMAC(config.MACAddress); err == nil {
+			vnic_mac = mac.String()
+		}
+	}
 	if vnic_mac == "" {
 		err := fmt.Errorf("Error getting VNIC MAC address")
 		state.Put("error", err)
@@ -42,6 +50,12 @@ func (s *stepWaitGuestAddress) Run(ctx context.Context, state multistep.StateBag
 
 	ui.Say(fmt.Sprintf("Waiting for the guest address to become available..."))
 	for {
+		// Give up if the VM has failed, e.g. by hitting max_reboots.
+		if err := driver.ExitError(); err != nil {
+			state.Put("error", err)
+			return multistep.ActionHalt
+		}
+
 		guestAddress := get_vnic_ip(vnic_mac)
 		if guestAddress != "" {
 			log.Printf("Found guest address %s", guestAddress)
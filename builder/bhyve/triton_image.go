@@ -0,0 +1,292 @@
+package bhyve
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+// TritonImageConfig overrides the fields of the manifest.json that is written
+// next to the zfs send stream, so that it can be installed with imgadm or
+// uploaded to IMGAPI.  Fields that are not set are derived from the rest of
+// the config.
+type TritonImageConfig struct {
+	CPUType      string                   `mapstructure:"cpu_type" required:"false"`
+	Description  string                   `mapstructure:"description" required:"false"`
+	DiskDriver   string                   `mapstructure:"disk_driver" required:"false"`
+	Homepage     string                   `mapstructure:"homepage" required:"false"`
+	ImageSize    int                      `mapstructure:"image_size" required:"false"`
+	Name         string                   `mapstructure:"name" required:"false"`
+	NICDriver    string                   `mapstructure:"nic_driver" required:"false"`
+	Origin       string                   `mapstructure:"origin" required:"false"`
+	OS           string                   `mapstructure:"os" required:"false"`
+	Owner        string                   `mapstructure:"owner" required:"false"`
+	Public       bool                     `mapstructure:"public" required:"false"`
+	Requirements TritonRequirementsConfig `mapstructure:"requirements" required:"false"`
+	Tags         map[string]string        `mapstructure:"tags" required:"false"`
+	UUID         string                   `mapstructure:"uuid" required:"false"`
+	Version      string                   `mapstructure:"version" required:"false"`
+}
+
+// TritonRequirementsConfig is the requirements object of the manifest.
+type TritonRequirementsConfig struct {
+	Bootrom  string                `mapstructure:"bootrom" required:"false"`
+	Brand    string                `mapstructure:"brand" required:"false"`
+	MaxRAM   int                   `mapstructure:"max_ram" required:"false"`
+	MinRAM   int                   `mapstructure:"min_ram" required:"false"`
+	Networks []TritonNetworkConfig `mapstructure:"networks" required:"false"`
+	SSHKey   bool                  `mapstructure:"ssh_key" required:"false"`
+}
+
+// TritonNetworkConfig is a network that an instance of the image requires.
+type TritonNetworkConfig struct {
+	Description string `mapstructure:"description" required:"false"`
+	Name        string `mapstructure:"name" required:"false"`
+}
+
+// The owner used by imgadm for images that do not belong to an account.
+const tritonNoOwner = "00000000-0000-0000-0000-000000000000"
+
+func (c *TritonImageConfig) Prepare(ctx *interpolate.Context, config *Config) (errs []error) {
+	// Every build is a new image, so the UUID is random rather than
+	// derived from vm_name like vm_uuid.
+	if c.UUID == "" {
+		c.UUID = uuid.New().String()
+	} else if _, err := uuid.Parse(c.UUID); err != nil {
+		errs = append(errs, fmt.Errorf("invalid triton_image uuid: %s", err))
+	}
+
+	if c.Owner == "" {
+		c.Owner = tritonNoOwner
+	} else if _, err := uuid.Parse(c.Owner); err != nil {
+		errs = append(errs, fmt.Errorf("invalid triton_image owner: %s", err))
+	}
+
+	if c.Origin != "" {
+		if _, err := uuid.Parse(c.Origin); err != nil {
+			errs = append(errs, fmt.Errorf("invalid triton_image origin: %s", err))
+		}
+	}
+
+	if c.Name == "" {
+		c.Name = config.VMName
+	}
+
+	if c.Version == "" {
+		c.Version = time.Now().UTC().Format("20060102T150405Z")
+	}
+
+	if c.OS == "" {
+		switch config.GuestOSType {
+		case "freebsd", "netbsd", "openbsd":
+			c.OS = "bsd"
+		default:
+			c.OS = config.GuestOSType
+		}
+	}
+	switch c.OS {
+	case "smartos", "linux", "windows", "bsd", "illumos", "other":
+	default:
+		errs = append(errs, fmt.Errorf(
+			"triton_image os must be one of smartos, linux, windows, bsd, illumos or other"))
+	}
+
+	if c.ImageSize < 0 {
+		errs = append(errs, fmt.Errorf("triton_image image_size must be positive"))
+	}
+
+	if c.NICDriver == "" {
+		switch config.NetDevice {
+		case "virtio-net-viona", "virtio-net":
+			c.NICDriver = "virtio"
+		default:
+			c.NICDriver = config.NetDevice
+		}
+	}
+
+	if c.DiskDriver == "" {
+		switch config.DiskInterface {
+		case "virtio-blk":
+			c.DiskDriver = "virtio"
+		case "ahci-hd":
+			c.DiskDriver = "ahci"
+		default:
+			c.DiskDriver = config.DiskInterface
+		}
+	}
+
+	if c.CPUType == "" {
+		c.CPUType = "host"
+	}
+
+	if c.Requirements.Brand == "" {
+		c.Requirements.Brand = "bhyve"
+	}
+
+	// A custom firmware image could be either, so it is left unset.
+	if c.Requirements.Bootrom == "" {
+		switch config.Firmware {
+		case "uefi":
+			c.Requirements.Bootrom = "uefi"
+		case "uefi-csm":
+			c.Requirements.Bootrom = "bios"
+		}
+	}
+
+	if c.Requirements.MaxRAM != 0 && c.Requirements.MaxRAM < c.Requirements.MinRAM {
+		errs = append(errs, fmt.Errorf("triton_image max_ram must not be less than min_ram"))
+	}
+
+	for i, network := range c.Requirements.Networks {
+		if network.Name == "" {
+			errs = append(errs, fmt.Errorf("triton_image networks[%d]: name is required", i))
+		}
+	}
+
+	return
+}
+
+// tritonManifest is an imgadm version 2 image manifest.
+type tritonManifest struct {
+	V            int                `json:"v"`
+	UUID         string             `json:"uuid"`
+	Owner        string             `json:"owner"`
+	Name         string             `json:"name"`
+	Version      string             `json:"version"`
+	State        string             `json:"state"`
+	Disabled     bool               `json:"disabled"`
+	Public       bool               `json:"public"`
+	PublishedAt  string             `json:"published_at"`
+	Type         string             `json:"type"`
+	OS           string             `json:"os"`
+	Origin       string             `json:"origin,omitempty"`
+	Files        []tritonFile       `json:"files"`
+	Description  string             `json:"description,omitempty"`
+	Homepage     string             `json:"homepage,omitempty"`
+	Requirements tritonRequirements `json:"requirements"`
+	Tags         map[string]string  `json:"tags,omitempty"`
+	ImageSize    int                `json:"image_size"`
+	NICDriver    string             `json:"nic_driver"`
+	DiskDriver   string             `json:"disk_driver"`
+	CPUType      string             `json:"cpu_type"`
+}
+
+type tritonFile struct {
+	SHA1        string `json:"sha1"`
+	Size        int64  `json:"size"`
+	Compression string `json:"compression"`
+}
+
+type tritonRequirements struct {
+	Brand    string          `json:"brand"`
+	Bootrom  string          `json:"bootrom,omitempty"`
+	MinRAM   int             `json:"min_ram,omitempty"`
+	MaxRAM   int             `json:"max_ram,omitempty"`
+	Networks []tritonNetwork `json:"networks,omitempty"`
+	SSHKey   bool            `json:"ssh_key,omitempty"`
+}
+
+type tritonNetwork struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// manifest returns the manifest for a zvol image of image_size MiB, whose
+// stream is file.
+func (c TritonImageConfig) manifest(file tritonFile, image_size int, published time.Time) tritonManifest {
+	if c.ImageSize != 0 {
+		image_size = c.ImageSize
+	}
+
+	networks := []tritonNetwork{}
+	for _, network := range c.Requirements.Networks {
+		networks = append(networks, tritonNetwork{
+			Name:        network.Name,
+			Description: network.Description,
+		})
+	}
+
+	return tritonManifest{
+		V:           2,
+		UUID:        c.UUID,
+		Owner:       c.Owner,
+		Name:        c.Name,
+		Version:     c.Version,
+		State:       "active",
+		Public:      c.Public,
+		PublishedAt: published.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Type:        "zvol",
+		OS:          c.OS,
+		Origin:      c.Origin,
+		Files:       []tritonFile{file},
+		Description: c.Description,
+		Homepage:    c.Homepage,
+		Requirements: tritonRequirements{
+			Brand:    c.Requirements.Brand,
+			Bootrom:  c.Requirements.Bootrom,
+			MinRAM:   c.Requirements.MinRAM,
+			MaxRAM:   c.Requirements.MaxRAM,
+			Networks: networks,
+			SSHKey:   c.Requirements.SSHKey,
+		},
+		Tags:       c.Tags,
+		ImageSize:  image_size,
+		NICDriver:  c.NICDriver,
+		DiskDriver: c.DiskDriver,
+		CPUType:    c.CPUType,
+	}
+}
+
+// writesTritonManifest returns whether a manifest is written, which is
+// whenever the boot disk is sent as a zfs stream.
+func (c *Config) writesTritonManifest() bool {
+	if !c.DiskUseZVOL {
+		return false
+	}
+	for _, format := range c.OutputFormat {
+		if format == "zfs" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTritonManifest checks that the manifest, if one is written, can be
+// imported by imgadm.
+func (c *Config) validateTritonManifest() []error {
+	var errs []error
+
+	if !c.writesTritonManifest() {
+		return errs
+	}
+
+	if _, err := tritonCompression(c.OutputCompression); err != nil {
+		errs = append(errs, err)
+	}
+
+	// imgadm will not import an incremental stream without the image it
+	// was sent from.
+	if c.BaseSnapshot != "" && c.TritonImage.Origin == "" {
+		errs = append(errs, fmt.Errorf(
+			"triton_image origin is required with zfs_send_base_snapshot"))
+	}
+
+	return errs
+}
+
+// tritonCompression returns the manifest compression for output_compression.
+// imgadm only understands some of the formats that can be written.
+func tritonCompression(compression string) (string, error) {
+	switch compression {
+	case "":
+		return "none", nil
+	case "gzip", "xz":
+		return compression, nil
+	default:
+		return "", fmt.Errorf(
+			"output_compression %s cannot be used in a Triton image manifest, use gzip, xz or none",
+			compression)
+	}
+}
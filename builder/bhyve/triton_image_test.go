@@ -0,0 +1,212 @@
+package bhyve
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTritonImageConfigPrepareDefaults(t *testing.T) {
+	config := &Config{}
+	config.VMName = "base"
+	config.GuestOSType = "freebsd"
+	config.NetDevice = "virtio-net-viona"
+	config.DiskInterface = "ahci-hd"
+	config.Firmware = "uefi-csm"
+
+	c := &TritonImageConfig{}
+	if errs := c.Prepare(nil, config); len(errs) != 0 {
+		t.Fatalf("Prepare() errors = %v", errs)
+	}
+
+	if c.UUID == "" || c.Version == "" {
+		t.Errorf("uuid %q and version %q should be set", c.UUID, c.Version)
+	}
+	if c.Owner != tritonNoOwner {
+		t.Errorf("owner = %q, want %q", c.Owner, tritonNoOwner)
+	}
+
+	checks := []struct {
+		field string
+		got   string
+		want  string
+	}{
+		{"name", c.Name, "base"},
+		{"os", c.OS, "bsd"},
+		{"nic_driver", c.NICDriver, "virtio"},
+		{"disk_driver", c.DiskDriver, "ahci"},
+		{"cpu_type", c.CPUType, "host"},
+		{"brand", c.Requirements.Brand, "bhyve"},
+		{"bootrom", c.Requirements.Bootrom, "bios"},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s = %q, want %q", check.field, check.got, check.want)
+		}
+	}
+}
+
+func TestTritonImageConfigPrepareErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config TritonImageConfig
+		errs   int
+	}{
+		{"valid", TritonImageConfig{
+			UUID:   "3b5e3c4e-0d6f-4f86-9d2a-1f3f1d7f0a6b",
+			Origin: "8c2e2a7c-5e2f-4b8d-9c1e-4a5f6b7c8d9e",
+			OS:     "smartos",
+		}, 0},
+		{"bad uuid", TritonImageConfig{UUID: "base"}, 1},
+		{"bad owner", TritonImageConfig{Owner: "me"}, 1},
+		{"bad origin", TritonImageConfig{Origin: "base@final"}, 1},
+		{"bad os", TritonImageConfig{OS: "plan9"}, 1},
+		{"negative image_size", TritonImageConfig{ImageSize: -1}, 1},
+		{"max_ram below min_ram", TritonImageConfig{
+			Requirements: TritonRequirementsConfig{MinRAM: 2048, MaxRAM: 1024},
+		}, 1},
+		{"network without name", TritonImageConfig{
+			Requirements: TritonRequirementsConfig{
+				Networks: []TritonNetworkConfig{{Name: "net0"}, {Description: "admin"}},
+			},
+		}, 1},
+	}
+
+	for _, tt := range tests {
+		config := &Config{}
+		config.GuestOSType = "linux"
+
+		if errs := tt.config.Prepare(nil, config); len(errs) != tt.errs {
+			t.Errorf("%s: errors = %v, want %d", tt.name, errs, tt.errs)
+		}
+	}
+}
+
+func TestTritonImageConfigManifest(t *testing.T) {
+	c := TritonImageConfig{
+		UUID:        "3b5e3c4e-0d6f-4f86-9d2a-1f3f1d7f0a6b",
+		Owner:       tritonNoOwner,
+		Name:        "base",
+		Version:     "1.0.0",
+		OS:          "linux",
+		Description: "A base image",
+		NICDriver:   "virtio",
+		DiskDriver:  "virtio",
+		CPUType:     "host",
+		Requirements: TritonRequirementsConfig{
+			Brand:    "bhyve",
+			Bootrom:  "uefi",
+			MinRAM:   1024,
+			Networks: []TritonNetworkConfig{{Name: "net0", Description: "public"}},
+		},
+	}
+	published := time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	file := tritonFile{SHA1: "da39a3ee5e6b4b0d3255bfef95601890afd80709", Size: 1234, Compression: "gzip"}
+
+	data, err := json.MarshalIndent(c.manifest(file, 10240, published), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+  "v": 2,
+  "uuid": "3b5e3c4e-0d6f-4f86-9d2a-1f3f1d7f0a6b",
+  "owner": "00000000-0000-0000-0000-000000000000",
+  "name": "base",
+  "version": "1.0.0",
+  "state": "active",
+  "disabled": false,
+  "public": false,
+  "published_at": "2024-01-02T03:04:05.006Z",
+  "type": "zvol",
+  "os": "linux",
+  "files": [
+    {
+      "sha1": "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+      "size": 1234,
+      "compression": "gzip"
+    }
+  ],
+  "description": "A base image",
+  "requirements": {
+    "brand": "bhyve",
+    "bootrom": "uefi",
+    "min_ram": 1024,
+    "networks": [
+      {
+        "name": "net0",
+        "description": "public"
+      }
+    ]
+  },
+  "image_size": 10240,
+  "nic_driver": "virtio",
+  "disk_driver": "virtio",
+  "cpu_type": "host"
+}`
+	if string(data) != want {
+		t.Errorf("manifest =\n%s\nwant\n%s", data, want)
+	}
+
+	// image_size overrides the size of the disk.
+	c.ImageSize = 20480
+	if got := c.manifest(file, 10240, published).ImageSize; got != 20480 {
+		t.Errorf("image_size = %d, want 20480", got)
+	}
+}
+
+func TestTritonCompression(t *testing.T) {
+	tests := []struct {
+		compression string
+		want        string
+		err         bool
+	}{
+		{"", "none", false},
+		{"gzip", "gzip", false},
+		{"xz", "xz", false},
+		{"zstd", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := tritonCompression(tt.compression)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("tritonCompression(%q) = %q, %v, want %q, error %v",
+				tt.compression, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestValidateTritonManifest(t *testing.T) {
+	const origin = "8c2e2a7c-5e2f-4b8d-9c1e-4a5f6b7c8d9e"
+
+	tests := []struct {
+		name        string
+		use_zvol    bool
+		formats     []string
+		compression string
+		base        string
+		origin      string
+		errs        int
+	}{
+		{"full stream", true, []string{"zfs"}, "gzip", "", "", 0},
+		{"incremental with origin", true, []string{"zfs"}, "", "zones/base@final", origin, 0},
+		{"incremental without origin", true, []string{"zfs"}, "", "zones/base@final", "", 1},
+		{"zstd", true, []string{"zfs"}, "zstd", "", "", 1},
+		{"zstd and no origin", true, []string{"zfs"}, "zstd", "zones/base@final", "", 2},
+		{"no manifest", true, []string{"raw"}, "zstd", "zones/base@final", "", 0},
+		{"file disk", false, []string{"zfs"}, "zstd", "", "", 0},
+	}
+
+	for _, tt := range tests {
+		c := &Config{}
+		c.DiskUseZVOL = tt.use_zvol
+		c.OutputFormat = tt.formats
+		c.OutputCompression = tt.compression
+		c.BaseSnapshot = tt.base
+		c.TritonImage.Origin = tt.origin
+
+		if errs := c.validateTritonManifest(); len(errs) != tt.errs {
+			t.Errorf("%s: errors = %v, want %d", tt.name, errs, tt.errs)
+		}
+	}
+}
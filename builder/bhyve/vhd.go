@@ -0,0 +1,111 @@
+package bhyve
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// See the Microsoft Virtual Hard Disk Image Format Specification for the
+// format.
+const (
+	vhdFooterSize       = 512
+	vhdSectorSize       = 512
+	vhdTypeFixed        = 2
+	vhdTypeDynamic      = 3
+	vhdTypeDifferencing = 4
+	vhdUnallocated      = 0xffffffff
+)
+
+var (
+	vhdCookie        = []byte("conectix")
+	vhdDynamicCookie = []byte("cxsparse")
+)
+
+// openVHD returns a reader for the virtual disk contents of a fixed or
+// dynamic VHD, and their size.  Differencing disks are not supported.
+func openVHD(f *os.File, fileSize int64) (io.Reader, int64, error) {
+	footer := make([]byte, vhdFooterSize)
+	if _, err := f.ReadAt(footer, fileSize-vhdFooterSize); err != nil {
+		return nil, 0, fmt.Errorf("cannot read VHD footer: %s", err)
+	}
+	if !bytes.HasPrefix(footer, vhdCookie) {
+		return nil, 0, fmt.Errorf("bad VHD footer cookie")
+	}
+
+	be := binary.BigEndian
+	size := int64(be.Uint64(footer[48:]))
+	if size < 0 {
+		return nil, 0, fmt.Errorf("invalid VHD size")
+	}
+
+	switch diskType := be.Uint32(footer[60:]); diskType {
+	case vhdTypeFixed:
+		if size > fileSize-vhdFooterSize {
+			return nil, 0, fmt.Errorf("fixed VHD is smaller than its disk size")
+		}
+		return io.NewSectionReader(f, 0, size), size, nil
+	case vhdTypeDynamic:
+	case vhdTypeDifferencing:
+		return nil, 0, fmt.Errorf("differencing VHDs are not supported")
+	default:
+		return nil, 0, fmt.Errorf("unsupported VHD disk type %d", diskType)
+	}
+
+	header := make([]byte, 1024)
+	if _, err := f.ReadAt(header, int64(be.Uint64(footer[16:]))); err != nil {
+		return nil, 0, fmt.Errorf("cannot read VHD dynamic disk header: %s", err)
+	}
+	if !bytes.HasPrefix(header, vhdDynamicCookie) {
+		return nil, 0, fmt.Errorf("bad VHD dynamic disk header cookie")
+	}
+
+	blockSize := int64(be.Uint32(header[32:]))
+	if blockSize == 0 || blockSize%vhdSectorSize != 0 || blockSize > diskImageMaxCluster {
+		return nil, 0, fmt.Errorf("invalid VHD block size %d", blockSize)
+	}
+
+	batOffset := int64(be.Uint64(header[16:]))
+	batCount := int64(be.Uint32(header[28:]))
+	if err := checkImageTable(batOffset, batCount, 4, fileSize); err != nil {
+		return nil, 0, fmt.Errorf("invalid VHD block allocation table: %s", err)
+	}
+
+	bat := make([]uint32, batCount)
+	if err := binary.Read(io.NewSectionReader(f, batOffset, batCount*4), be, bat); err != nil {
+		return nil, 0, fmt.Errorf("cannot read VHD block allocation table: %s", err)
+	}
+
+	// Each block starts with a bitmap of the sectors in it that are in
+	// use, padded to a whole sector.
+	sectors := blockSize / vhdSectorSize
+	bitmapSize := ((sectors+7)/8 + vhdSectorSize - 1) / vhdSectorSize * vhdSectorSize
+	bitmap := make([]byte, bitmapSize)
+	zero := make([]byte, vhdSectorSize)
+
+	read := func(index int64, buf []byte) error {
+		if index >= int64(len(bat)) || bat[index] == vhdUnallocated {
+			return nil
+		}
+
+		offset := int64(bat[index]) * vhdSectorSize
+		if _, err := f.ReadAt(bitmap, offset); err != nil {
+			return fmt.Errorf("cannot read VHD block bitmap: %s", err)
+		}
+		if _, err := f.ReadAt(buf, offset+bitmapSize); err != nil && err != io.EOF {
+			return err
+		}
+
+		// Sectors that are not in use read as zeros.
+		for s := int64(0); s < sectors; s++ {
+			if bitmap[s/8]&(0x80>>(s%8)) == 0 {
+				copy(buf[s*vhdSectorSize:(s+1)*vhdSectorSize], zero)
+			}
+		}
+		return nil
+	}
+
+	return newClusterReader(size, blockSize, read), size, nil
+}
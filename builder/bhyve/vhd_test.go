@@ -0,0 +1,191 @@
+package bhyve
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The block size of the dynamic VHDs written by the tests, which is smaller
+// than the usual 2 MiB to keep them small.
+const vhdTestBlockSize = 16 * 1024
+
+// vhdFooter returns a VHD footer for a disk of size bytes.  openVHD does not
+// check the checksum, so it is left as zero.
+func vhdFooter(size int64, diskType uint32, dataOffset uint64) []byte {
+	be := binary.BigEndian
+	footer := make([]byte, vhdFooterSize)
+	copy(footer, vhdCookie)
+	be.PutUint32(footer[8:], 2)
+	be.PutUint32(footer[12:], 0x00010000)
+	be.PutUint64(footer[16:], dataOffset)
+	be.PutUint64(footer[40:], uint64(size))
+	be.PutUint64(footer[48:], uint64(size))
+	be.PutUint32(footer[60:], diskType)
+	return footer
+}
+
+// writeFixedVHD returns disk as a fixed VHD.
+func writeFixedVHD(disk []byte) []byte {
+	return append(append([]byte{}, disk...), vhdFooter(int64(len(disk)), vhdTypeFixed, vhdUnallocated)...)
+}
+
+// writeDynamicVHD returns disk as a dynamic VHD.  Blocks of zeros are not
+// allocated, and in the allocated blocks only the sectors that are not zero
+// are marked as in use, with the rest filled with 0xff so that reading them
+// as zeros relies on the bitmap.
+func writeDynamicVHD(disk []byte) []byte {
+	be := binary.BigEndian
+	blocks := (len(disk) + vhdTestBlockSize - 1) / vhdTestBlockSize
+	batSize := (blocks*4 + vhdSectorSize - 1) / vhdSectorSize * vhdSectorSize
+
+	footer := vhdFooter(int64(len(disk)), vhdTypeDynamic, vhdFooterSize)
+	header := make([]byte, 1024)
+	copy(header, vhdDynamicCookie)
+	be.PutUint64(header[8:], 0xffffffffffffffff)
+	be.PutUint64(header[16:], vhdFooterSize+1024)
+	be.PutUint32(header[24:], 0x00010000)
+	be.PutUint32(header[28:], uint32(blocks))
+	be.PutUint32(header[32:], vhdTestBlockSize)
+
+	bat := make([]byte, batSize)
+	for i := range bat {
+		bat[i] = 0xff
+	}
+
+	var out bytes.Buffer
+	out.Write(footer)
+	out.Write(header)
+	out.Write(bat)
+
+	zero := make([]byte, vhdSectorSize)
+	for b := 0; b < blocks; b++ {
+		block := make([]byte, vhdTestBlockSize)
+		copy(block, disk[b*vhdTestBlockSize:])
+		if bytes.Equal(block, make([]byte, vhdTestBlockSize)) {
+			continue
+		}
+
+		be.PutUint32(bat[b*4:], uint32(out.Len()/vhdSectorSize))
+
+		bitmap := make([]byte, vhdSectorSize)
+		for s := 0; s < vhdTestBlockSize/vhdSectorSize; s++ {
+			sector := block[s*vhdSectorSize : (s+1)*vhdSectorSize]
+			if bytes.Equal(sector, zero) {
+				for i := range sector {
+					sector[i] = 0xff
+				}
+			} else {
+				bitmap[s/8] |= 0x80 >> (s % 8)
+			}
+		}
+		out.Write(bitmap)
+		out.Write(block)
+	}
+	out.Write(footer)
+
+	// The BAT was filled in after it was written.
+	vhd := out.Bytes()
+	copy(vhd[vhdFooterSize+1024:], bat)
+
+	return vhd
+}
+
+func TestVHDRead(t *testing.T) {
+	// Two blocks of data, one of zeros, and a partial block with data in
+	// only some of its sectors.
+	disk := repeatedDisk(4 * vhdTestBlockSize)
+	for i := 2 * vhdTestBlockSize; i < 3*vhdTestBlockSize; i++ {
+		disk[i] = 0
+	}
+	for i := 3*vhdTestBlockSize + 2*vhdSectorSize; i < len(disk); i++ {
+		disk[i] = 0
+	}
+
+	tests := []struct {
+		name string
+		vhd  []byte
+	}{
+		{"fixed", writeFixedVHD(disk)},
+		{"dynamic", writeDynamicVHD(disk)},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "disk.vhd")
+		if err := os.WriteFile(path, tt.vhd, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, size, format, err := openDiskImage(f)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if format != "VHD" {
+			t.Fatalf("%s: format %s, want VHD", tt.name, format)
+		}
+		if size != int64(len(disk)) {
+			t.Fatalf("%s: size %d, want %d", tt.name, size, len(disk))
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		f.Close()
+
+		if !bytes.Equal(got, disk) {
+			t.Fatalf("%s: contents differ", tt.name)
+		}
+	}
+}
+
+func TestVHDDifferencing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disk.vhd")
+	vhd := append(vhdFooter(vhdTestBlockSize, vhdTypeDifferencing, vhdFooterSize),
+		vhdFooter(vhdTestBlockSize, vhdTypeDifferencing, vhdFooterSize)...)
+	if err := os.WriteFile(path, vhd, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, _, _, err := openDiskImage(f); err == nil {
+		t.Fatal("differencing VHD should not be supported")
+	}
+}
+
+func TestVHDCorrupt(t *testing.T) {
+	disk := repeatedDisk(2 * vhdTestBlockSize)
+	dynamic := writeDynamicVHD(disk)
+	fixed := writeFixedVHD(disk)
+
+	tests := []struct {
+		name   string
+		image  []byte
+		offset int64
+		value  uint64
+		size   int
+	}{
+		{"negative size", dynamic, int64(len(dynamic)) - vhdFooterSize + 48, 1 << 63, 8},
+		{"fixed larger than the file", fixed, int64(len(fixed)) - vhdFooterSize + 48, 1 << 40, 8},
+		{"huge block size", dynamic, vhdFooterSize + 32, 0x80000000, 4},
+		{"huge BAT", dynamic, vhdFooterSize + 28, 0xffffffff, 4},
+		{"BAT past the end", dynamic, vhdFooterSize + 16, uint64(len(dynamic)), 8},
+	}
+
+	for _, tt := range tests {
+		if err := openCorruptImage(t, tt.image, tt.offset, tt.value, tt.size, binary.BigEndian); err == nil {
+			t.Errorf("%s: opened without error", tt.name)
+		}
+	}
+}
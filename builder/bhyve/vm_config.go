@@ -0,0 +1,212 @@
+package bhyve
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// vmSetting is a single key=value line of a bhyve configuration file.
+type vmSetting struct {
+	key   string
+	value string
+}
+
+// vmDevice is a PCI device on bus 0, its settings are rendered relative to
+// pci.0.<slot>.<function>.
+type vmDevice struct {
+	slot     int
+	function int
+	settings []vmSetting
+}
+
+func (dev *vmDevice) set(key string, value string) *vmDevice {
+	for i := range dev.settings {
+		if dev.settings[i].key == key {
+			dev.settings[i].value = value
+			return dev
+		}
+	}
+	dev.settings = append(dev.settings, vmSetting{key, value})
+	return dev
+}
+
+// vmConfig is a structured description of the guest hardware, which is
+// rendered to a configuration file for bhyve -k, see bhyve_config(5).
+type vmConfig struct {
+	settings []vmSetting
+	devices  []*vmDevice
+}
+
+func newVMConfig(name string) *vmConfig {
+	vm := &vmConfig{}
+	vm.set("name", name)
+	return vm
+}
+
+// set adds a top-level setting, replacing any existing one with the same
+// key.
+func (vm *vmConfig) set(key string, value string) {
+	for i := range vm.settings {
+		if vm.settings[i].key == key {
+			vm.settings[i].value = value
+			return
+		}
+	}
+	vm.settings = append(vm.settings, vmSetting{key, value})
+}
+
+// unset removes top-level settings whose keys are key or start with key
+// followed by a dot.
+func (vm *vmConfig) unset(key string) {
+	settings := []vmSetting{}
+	for _, setting := range vm.settings {
+		if setting.key != key && !strings.HasPrefix(setting.key, key+".") {
+			settings = append(settings, setting)
+		}
+	}
+	vm.settings = settings
+}
+
+// addDevice adds a PCI device with the given emulation.
+func (vm *vmConfig) addDevice(slot int, function int, emulation string) *vmDevice {
+	dev := &vmDevice{slot: slot, function: function}
+	dev.set("device", emulation)
+	vm.devices = append(vm.devices, dev)
+	return dev
+}
+
+// diskOptions are the blockif options for a disk.
+type diskOptions struct {
+	cache      string
+	sectorSize string
+	readOnly   bool
+}
+
+// addDisk adds a disk backed by path using the configured interface, with
+// the given cache mode, sector size and read-only setting.
+func (vm *vmConfig) addDisk(slot int, c *Config, path string, opts diskOptions) {
+	var dev *vmDevice
+	prefix := ""
+
+	switch c.DiskInterface {
+	case "ahci-hd":
+		prefix = "port.0."
+		dev = vm.addDevice(slot, 0, "ahci").
+			set(prefix+"type", "hd")
+	default:
+		dev = vm.addDevice(slot, 0, c.DiskInterface)
+	}
+	dev.set(prefix+"path", path)
+
+	// Map qemu's cache modes on to the blockif options.
+	switch opts.cache {
+	case "writethrough":
+		dev.set(prefix+"direct", "true")
+	case "none":
+		dev.set(prefix+"nocache", "true")
+	case "directsync":
+		dev.set(prefix+"nocache", "true")
+		dev.set(prefix+"direct", "true")
+	}
+
+	if opts.sectorSize != "" {
+		if c.DiskInterface == "nvme" {
+			dev.set("sectsz", opts.sectorSize)
+		} else {
+			dev.set(prefix+"sectorsize", opts.sectorSize)
+		}
+	}
+
+	if opts.readOnly {
+		dev.set(prefix+"ro", "true")
+	}
+}
+
+// addCD adds an AHCI CD-ROM backed by path.
+func (vm *vmConfig) addCD(slot int, path string) {
+	vm.addDevice(slot, 0, "ahci").
+		set("port.0.type", "cd").
+		set("port.0.path", path)
+}
+
+// addNIC adds a NIC on the named VNIC.
+func (vm *vmConfig) addNIC(slot int, device string, vnic string, mac string) {
+	dev := vm.addDevice(slot, 0, device).
+		set("vnic", vnic)
+
+	// viona always uses the MAC address of the VNIC.
+	if mac != "" && device != "virtio-net-viona" {
+		dev.set("mac", mac)
+	}
+}
+
+// clone returns a copy of the description that can be modified without
+// affecting the original.
+func (vm *vmConfig) clone() *vmConfig {
+	c := &vmConfig{
+		settings: append([]vmSetting{}, vm.settings...),
+	}
+	for _, dev := range vm.devices {
+		d := *dev
+		d.settings = append([]vmSetting{}, dev.settings...)
+		c.devices = append(c.devices, &d)
+	}
+	return c
+}
+
+// The device settings whose values are secret.
+var vmSecretKeys = map[string]bool{
+	"password": true,
+}
+
+// redacted returns a copy of the description with the secret settings, such
+// as the framebuffer password, replaced, so that it can be logged or kept in
+// the output directory.
+func (vm *vmConfig) redacted() *vmConfig {
+	c := vm.clone()
+	for _, dev := range c.devices {
+		for i := range dev.settings {
+			if vmSecretKeys[dev.settings[i].key] {
+				dev.settings[i].value = "<redacted>"
+			}
+		}
+	}
+	return c
+}
+
+// render returns the contents of the configuration file, with the devices
+// ordered by slot.
+func (vm *vmConfig) render() string {
+	var b strings.Builder
+
+	for _, s := range vm.settings {
+		fmt.Fprintf(&b, "%s=%s\n", s.key, s.value)
+	}
+
+	devices := append([]*vmDevice{}, vm.devices...)
+	sort.SliceStable(devices, func(i, j int) bool {
+		if devices[i].slot != devices[j].slot {
+			return devices[i].slot < devices[j].slot
+		}
+		return devices[i].function < devices[j].function
+	})
+
+	for _, dev := range devices {
+		for _, s := range dev.settings {
+			fmt.Fprintf(&b, "pci.0.%d.%d.%s=%s\n",
+				dev.slot, dev.function, s.key, s.value)
+		}
+	}
+
+	return b.String()
+}
+
+// write renders the configuration file to path.
+func (vm *vmConfig) write(path string) error {
+	if err := os.WriteFile(path, []byte(vm.render()), 0600); err != nil {
+		return fmt.Errorf("Error writing bhyve config %s: %s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package bhyve
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVMConfigRender(t *testing.T) {
+	c := &Config{}
+	c.DiskInterface = "ahci-hd"
+
+	vm := newVMConfig("test")
+	vm.set("cpus", "2")
+	vm.set("lpc.com1.path", "/tmp/com1")
+	vm.set("cpus", "4")
+	vm.addNIC(SlotNIC, "virtio-net-viona", "test0", "02:08:20:00:00:01")
+	vm.addDisk(SlotBootDisk, c, "/dev/zvol/rdsk/zones/test", diskOptions{
+		cache:      "directsync",
+		sectorSize: "512/4096",
+		readOnly:   true,
+	})
+	vm.addDevice(0, 0, "hostbridge")
+	vm.addCD(SlotCDROM, "/tmp/test.iso")
+	vm.addNIC(SlotNIC+1, "e1000", "test1", "02:08:20:00:00:02")
+
+	want := `name=test
+cpus=4
+lpc.com1.path=/tmp/com1
+pci.0.0.0.device=hostbridge
+pci.0.3.0.device=ahci
+pci.0.3.0.port.0.type=cd
+pci.0.3.0.port.0.path=/tmp/test.iso
+pci.0.4.0.device=ahci
+pci.0.4.0.port.0.type=hd
+pci.0.4.0.port.0.path=/dev/zvol/rdsk/zones/test
+pci.0.4.0.port.0.nocache=true
+pci.0.4.0.port.0.direct=true
+pci.0.4.0.port.0.sectorsize=512/4096
+pci.0.4.0.port.0.ro=true
+pci.0.6.0.device=virtio-net-viona
+pci.0.6.0.vnic=test0
+pci.0.7.0.device=e1000
+pci.0.7.0.vnic=test1
+pci.0.7.0.mac=02:08:20:00:00:02
+`
+	if got := vm.render(); got != want {
+		t.Errorf("render() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestVMConfigAddDisk(t *testing.T) {
+	tests := []struct {
+		iface string
+		opts  diskOptions
+		want  string
+	}{
+		{"virtio-blk", diskOptions{}, `pci.0.4.0.device=virtio-blk
+pci.0.4.0.path=/tmp/disk
+`},
+		{"virtio-blk", diskOptions{cache: "writethrough", sectorSize: "4096"}, `pci.0.4.0.device=virtio-blk
+pci.0.4.0.path=/tmp/disk
+pci.0.4.0.direct=true
+pci.0.4.0.sectorsize=4096
+`},
+		{"nvme", diskOptions{cache: "none", sectorSize: "4096", readOnly: true}, `pci.0.4.0.device=nvme
+pci.0.4.0.path=/tmp/disk
+pci.0.4.0.nocache=true
+pci.0.4.0.sectsz=4096
+pci.0.4.0.ro=true
+`},
+	}
+
+	for _, tt := range tests {
+		c := &Config{}
+		c.DiskInterface = tt.iface
+
+		vm := &vmConfig{}
+		vm.addDisk(SlotBootDisk, c, "/tmp/disk", tt.opts)
+		if got := vm.render(); got != tt.want {
+			t.Errorf("%s %+v: render() =\n%s\nwant\n%s", tt.iface, tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestVMConfigClone(t *testing.T) {
+	vm := newVMConfig("test")
+	vm.addCD(SlotCDROM, "/tmp/test.iso")
+
+	c := vm.clone()
+	c.set("name", "clone")
+	c.devices[0].set("port.0.path", "/tmp/other.iso")
+
+	want := `name=test
+pci.0.3.0.device=ahci
+pci.0.3.0.port.0.type=cd
+pci.0.3.0.port.0.path=/tmp/test.iso
+`
+	if got := vm.render(); got != want {
+		t.Errorf("render() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestVMConfigRedacted(t *testing.T) {
+	vm := newVMConfig("test")
+	vm.addDevice(SlotFBuf, 0, "fbuf").
+		set("tcp", "127.0.0.1:5900").
+		set("password", "secret")
+
+	want := `name=test
+pci.0.30.0.device=fbuf
+pci.0.30.0.tcp=127.0.0.1:5900
+pci.0.30.0.password=<redacted>
+`
+	if got := vm.redacted().render(); got != want {
+		t.Errorf("redacted().render() =\n%s\nwant\n%s", got, want)
+	}
+
+	// The original keeps the password for bhyve.
+	if got := vm.render(); !strings.Contains(got, "password=secret") {
+		t.Errorf("render() =\n%s\nwant the password", got)
+	}
+}
@@ -0,0 +1,381 @@
+package bhyve
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// See the VMware Virtual Disk Format 5.0 specification for the format of
+// stream-optimized sparse extents.
+const (
+	vmdkSectorSize        = 512
+	vmdkGrainSectors      = 128
+	vmdkGrainSize         = vmdkGrainSectors * vmdkSectorSize
+	vmdkGTEs              = 512
+	vmdkGTSectors         = vmdkGTEs * 4 / vmdkSectorSize
+	vmdkDescriptorSectors = 20
+	vmdkOverhead          = vmdkGrainSectors
+	vmdkVersion           = 3
+	vmdkFlags             = 0x1 | 0x10000 | 0x20000
+	vmdkGDAtEnd           = 0xffffffffffffffff
+	vmdkCompressDeflate   = 1
+	vmdkMarkerEOS         = 0
+	vmdkMarkerGT          = 1
+	vmdkMarkerGD          = 2
+	vmdkMarkerFooter      = 3
+)
+
+var vmdkMagic = []byte{'K', 'D', 'M', 'V'}
+
+// vmdkHeader returns a sparse extent header for a disk of capacity sectors.
+func vmdkHeader(capacity uint64, gdOffset uint64) []byte {
+	header := make([]byte, vmdkSectorSize)
+	copy(header[0:], vmdkMagic)
+	binary.LittleEndian.PutUint32(header[4:], vmdkVersion)
+	binary.LittleEndian.PutUint32(header[8:], vmdkFlags)
+	binary.LittleEndian.PutUint64(header[12:], capacity)
+	binary.LittleEndian.PutUint64(header[20:], vmdkGrainSectors)
+	binary.LittleEndian.PutUint64(header[28:], 1)
+	binary.LittleEndian.PutUint64(header[36:], vmdkDescriptorSectors)
+	binary.LittleEndian.PutUint32(header[44:], vmdkGTEs)
+	binary.LittleEndian.PutUint64(header[48:], 0)
+	binary.LittleEndian.PutUint64(header[56:], gdOffset)
+	binary.LittleEndian.PutUint64(header[64:], vmdkOverhead)
+	header[72] = 0
+	copy(header[73:], "\n \r\n")
+	binary.LittleEndian.PutUint16(header[77:], vmdkCompressDeflate)
+	return header
+}
+
+// vmdkDescriptor returns the embedded descriptor for a disk of capacity
+// sectors in the extent file named name.
+func vmdkDescriptor(capacity uint64, name string) ([]byte, error) {
+	cid := make([]byte, 4)
+	if _, err := rand.Read(cid); err != nil {
+		return nil, err
+	}
+
+	cylinders := capacity / (16 * 63)
+	if cylinders > 16383 {
+		cylinders = 16383
+	}
+
+	descriptor := fmt.Sprintf(`# Disk DescriptorFile
+version=1
+CID=%x
+parentCID=ffffffff
+createType="streamOptimized"
+
+# Extent description
+RW %d SPARSE "%s"
+
+# The Disk Data Base
+#DDB
+
+ddb.virtualHWVersion = "4"
+ddb.geometry.cylinders = "%d"
+ddb.geometry.heads = "16"
+ddb.geometry.sectors = "63"
+ddb.adapterType = "lsilogic"
+`, cid, capacity, name, cylinders)
+
+	if len(descriptor) > vmdkDescriptorSectors*vmdkSectorSize {
+		return nil, fmt.Errorf("VMDK descriptor is too large")
+	}
+
+	return []byte(descriptor), nil
+}
+
+// vmdkMarker returns a metadata marker sector of the given type.
+func vmdkMarker(sectors uint64, marker uint32) []byte {
+	buf := make([]byte, vmdkSectorSize)
+	binary.LittleEndian.PutUint64(buf[0:], sectors)
+	binary.LittleEndian.PutUint32(buf[12:], marker)
+	return buf
+}
+
+// writeVMDK writes the size bytes read from src to dst as a stream-optimized
+// VMDK.  Grains of zeros are left out, and the rest are compressed.
+func writeVMDK(dst *os.File, src io.Reader, size int64) error {
+	capacity := uint64((size + vmdkSectorSize - 1) / vmdkSectorSize)
+	grains := (size + vmdkGrainSize - 1) / vmdkGrainSize
+	gts := (grains + vmdkGTEs - 1) / vmdkGTEs
+
+	descriptor, err := vmdkDescriptor(capacity, filepath.Base(dst.Name()))
+	if err != nil {
+		return err
+	}
+
+	// The header at the start says that the grain directory is at the
+	// end, and the footer has its real location.
+	var out bytes.Buffer
+	out.Write(vmdkHeader(capacity, vmdkGDAtEnd))
+	out.Write(descriptor)
+	out.Write(make([]byte, vmdkOverhead*vmdkSectorSize-out.Len()))
+
+	sector := uint64(vmdkOverhead)
+	flush := func() error {
+		if _, err := dst.Write(out.Bytes()); err != nil {
+			return err
+		}
+		out.Reset()
+		return nil
+	}
+	pad := func() {
+		if n := out.Len() % vmdkSectorSize; n != 0 {
+			out.Write(make([]byte, vmdkSectorSize-n))
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	gt := make([]uint32, gts*vmdkGTEs)
+	buf := make([]byte, vmdkGrainSize)
+	zero := make([]byte, vmdkGrainSize)
+	var compressed bytes.Buffer
+	deflater, _ := zlib.NewWriterLevel(&compressed, zlib.BestCompression)
+
+	for grain := int64(0); grain < grains; grain++ {
+		n, err := io.ReadFull(src, buf)
+		if err == io.ErrUnexpectedEOF && grain == grains-1 {
+			copy(buf[n:], zero)
+		} else if err != nil {
+			return fmt.Errorf("Error reading disk: %s", err)
+		}
+
+		if bytes.Equal(buf, zero) {
+			continue
+		}
+
+		compressed.Reset()
+		deflater.Reset(&compressed)
+		deflater.Write(buf)
+		deflater.Close()
+
+		// A grain marker is the guest sector followed by the length
+		// and the compressed data.
+		marker := make([]byte, 12)
+		binary.LittleEndian.PutUint64(marker[0:], uint64(grain*vmdkGrainSectors))
+		binary.LittleEndian.PutUint32(marker[8:], uint32(compressed.Len()))
+		out.Write(marker)
+		out.Write(compressed.Bytes())
+		pad()
+
+		gt[grain] = uint32(sector)
+		sector += uint64(out.Len() / vmdkSectorSize)
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	// Grain tables, leaving out any that are empty.
+	gd := make([]uint32, gts)
+	for i := int64(0); i < gts; i++ {
+		entries := gt[i*vmdkGTEs : (i+1)*vmdkGTEs]
+		empty := true
+		for _, entry := range entries {
+			if entry != 0 {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			continue
+		}
+
+		out.Write(vmdkMarker(vmdkGTSectors, vmdkMarkerGT))
+		gd[i] = uint32(sector + 1)
+		binary.Write(&out, binary.LittleEndian, entries)
+		sector += 1 + vmdkGTSectors
+	}
+
+	// The grain directory, footer and end of stream marker.
+	gdSectors := (uint64(gts)*4 + vmdkSectorSize - 1) / vmdkSectorSize
+	out.Write(vmdkMarker(gdSectors, vmdkMarkerGD))
+	gdOffset := sector + 1
+	binary.Write(&out, binary.LittleEndian, gd)
+	pad()
+	sector += 1 + gdSectors
+
+	out.Write(vmdkMarker(1, vmdkMarkerFooter))
+	out.Write(vmdkHeader(capacity, gdOffset))
+	out.Write(vmdkMarker(0, vmdkMarkerEOS))
+
+	return flush()
+}
+
+// checkVMDK reads back the header and footer of the stream-optimized VMDK
+// at path, and checks that they describe a disk of size bytes.
+func checkVMDK(path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	capacity := uint64((size + vmdkSectorSize - 1) / vmdkSectorSize)
+	header := make([]byte, vmdkSectorSize)
+
+	check := func(offset int64, what string) (uint64, error) {
+		if _, err := f.ReadAt(header, offset); err != nil {
+			return 0, fmt.Errorf("%s: cannot read VMDK %s: %s", path, what, err)
+		}
+		if !bytes.Equal(header[0:4], vmdkMagic) {
+			return 0, fmt.Errorf("%s: bad VMDK %s magic", path, what)
+		}
+		if v := binary.LittleEndian.Uint32(header[4:]); v != vmdkVersion {
+			return 0, fmt.Errorf("%s: unsupported VMDK version %d", path, v)
+		}
+		if v := binary.LittleEndian.Uint64(header[12:]); v != capacity {
+			return 0, fmt.Errorf("%s: VMDK capacity is %d sectors, expected %d",
+				path, v, capacity)
+		}
+		return binary.LittleEndian.Uint64(header[56:]), nil
+	}
+
+	if _, err := check(0, "header"); err != nil {
+		return err
+	}
+
+	gdOffset, err := check(info.Size()-2*vmdkSectorSize, "footer")
+	if err != nil {
+		return err
+	}
+	if gdOffset == vmdkGDAtEnd || int64(gdOffset)*vmdkSectorSize >= info.Size() {
+		return fmt.Errorf("%s: invalid VMDK grain directory offset", path)
+	}
+
+	return nil
+}
+
+// The flag for compressed grains in a sparse extent header.
+const vmdkFlagCompressed = 0x10000
+
+// openVMDK returns a reader for the virtual disk contents of a monolithic
+// sparse or stream-optimized VMDK, and their size.
+func openVMDK(f *os.File) (io.Reader, int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	header := make([]byte, vmdkSectorSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return nil, 0, err
+	}
+
+	// Stream-optimized images have the grain directory location in the
+	// footer.
+	le := binary.LittleEndian
+	if le.Uint64(header[56:]) == vmdkGDAtEnd {
+		if _, err := f.ReadAt(header, info.Size()-2*vmdkSectorSize); err != nil {
+			return nil, 0, fmt.Errorf("cannot read VMDK footer: %s", err)
+		}
+		if !bytes.Equal(header[0:4], vmdkMagic) {
+			return nil, 0, fmt.Errorf("bad VMDK footer magic")
+		}
+	}
+
+	flags := le.Uint32(header[8:])
+	capacity := le.Uint64(header[12:])
+	grainSectors := le.Uint64(header[20:])
+	gtes := int64(le.Uint32(header[44:]))
+	gdSector := le.Uint64(header[56:])
+	if capacity > math.MaxInt64/vmdkSectorSize || gdSector > math.MaxInt64/vmdkSectorSize {
+		return nil, 0, fmt.Errorf("invalid VMDK header")
+	}
+	if grainSectors == 0 || grainSectors > diskImageMaxCluster/vmdkSectorSize {
+		return nil, 0, fmt.Errorf("invalid VMDK grain size of %d sectors", grainSectors)
+	}
+	if gtes == 0 || gtes > diskImageMaxTable/4 {
+		return nil, 0, fmt.Errorf("invalid VMDK grain table size of %d entries", gtes)
+	}
+	size := int64(capacity) * vmdkSectorSize
+	grainSize := int64(grainSectors) * vmdkSectorSize
+	gdOffset := int64(gdSector) * vmdkSectorSize
+	compressed := flags&vmdkFlagCompressed != 0
+
+	grains := size / grainSize
+	if size%grainSize != 0 {
+		grains++
+	}
+	gdCount := (grains + gtes - 1) / gtes
+	if err := checkImageTable(gdOffset, gdCount, 4, info.Size()); err != nil {
+		return nil, 0, fmt.Errorf("invalid VMDK grain directory: %s", err)
+	}
+
+	gd := make([]uint32, gdCount)
+	if err := binary.Read(io.NewSectionReader(f, gdOffset, gdCount*4), le, gd); err != nil {
+		return nil, 0, fmt.Errorf("cannot read VMDK grain directory: %s", err)
+	}
+
+	// Cache the grain table in use, as the grains are read in order.
+	gt := make([]uint32, gtes)
+	gtIndex := int64(-1)
+	marker := make([]byte, 12)
+	data := make([]byte, 2*grainSize)
+
+	read := func(index int64, buf []byte) error {
+		table := index / gtes
+		if gd[table] == 0 {
+			return nil
+		}
+		if table != gtIndex {
+			if err := binary.Read(io.NewSectionReader(f, int64(gd[table])*vmdkSectorSize, gtes*4),
+				le, gt); err != nil {
+				return fmt.Errorf("cannot read VMDK grain table: %s", err)
+			}
+			gtIndex = table
+		}
+
+		// Entries of 1 are grains of zeros.
+		sector := int64(gt[index%gtes])
+		if sector <= 1 {
+			return nil
+		}
+		offset := sector * vmdkSectorSize
+
+		if !compressed {
+			if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+				return err
+			}
+			return nil
+		}
+
+		if _, err := f.ReadAt(marker, offset); err != nil {
+			return err
+		}
+		length := int64(le.Uint32(marker[8:]))
+		if length > int64(len(data)) {
+			return fmt.Errorf("VMDK grain %d is too large", index)
+		}
+		if _, err := f.ReadAt(data[:length], offset+12); err != nil && err != io.EOF {
+			return err
+		}
+
+		inflater, err := zlib.NewReader(bytes.NewReader(data[:length]))
+		if err != nil {
+			return fmt.Errorf("cannot decompress VMDK grain %d: %s", index, err)
+		}
+		defer inflater.Close()
+		if _, err := io.ReadFull(inflater, buf); err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("cannot decompress VMDK grain %d: %s", index, err)
+		}
+		return nil
+	}
+
+	return newClusterReader(size, grainSize, read), size, nil
+}
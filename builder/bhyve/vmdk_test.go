@@ -0,0 +1,98 @@
+package bhyve
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVMDKRoundTrip(t *testing.T) {
+	// Enough grains for two grain tables, with data at the start, a grain
+	// table of zeros, and data in the last grain.
+	size := (vmdkGTEs + 3) * vmdkGrainSize
+	disk := make([]byte, size)
+	copy(disk, repeatedDisk(3*vmdkGrainSize+1000))
+	copy(disk[size-vmdkGrainSize:], repeatedDisk(vmdkGrainSize))
+
+	path := filepath.Join(t.TempDir(), "disk.vmdk")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeVMDK(f, bytes.NewReader(disk), int64(size)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := checkVMDK(path, int64(size)); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, gotSize, format, err := openDiskImage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "VMDK" {
+		t.Fatalf("format %s, want VMDK", format)
+	}
+	if gotSize != int64(size) {
+		t.Fatalf("size %d, want %d", gotSize, size)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, disk) {
+		t.Fatal("contents differ")
+	}
+}
+
+func TestVMDKCorrupt(t *testing.T) {
+	disk := repeatedDisk(4 * vmdkGrainSize)
+	path := filepath.Join(t.TempDir(), "disk.vmdk")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeVMDK(f, bytes.NewReader(disk), int64(len(disk))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	image, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The header in the footer is the one that is used.
+	footer := int64(len(image)) - 2*vmdkSectorSize
+
+	tests := []struct {
+		name   string
+		offset int64
+		value  uint64
+		size   int
+	}{
+		{"huge capacity", 12, 1 << 62, 8},
+		{"huge disk", 12, 1 << 50, 8},
+		{"zero grain size", 20, 0, 8},
+		{"huge grain size", 20, 1 << 40, 8},
+		{"huge grain table", 44, 0xffffffff, 4},
+		{"grain directory past the end", 56, uint64(len(image)), 8},
+	}
+
+	for _, tt := range tests {
+		if err := openCorruptImage(t, image, footer+tt.offset, tt.value, tt.size, binary.LittleEndian); err == nil {
+			t.Errorf("%s: opened without error", tt.name)
+		}
+	}
+}
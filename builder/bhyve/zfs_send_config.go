@@ -0,0 +1,84 @@
+package bhyve
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+// ZFSSendConfig holds the options for the zfs send stream that is written
+// to the output directory for zvol builds.
+type ZFSSendConfig struct {
+	BaseSnapshot  string `mapstructure:"zfs_send_base_snapshot" required:"false"`
+	Compressed    bool   `mapstructure:"zfs_send_compressed" required:"false"`
+	Embedded      bool   `mapstructure:"zfs_send_embedded" required:"false"`
+	Intermediates bool   `mapstructure:"zfs_send_intermediates" required:"false"`
+	LargeBlocks   bool   `mapstructure:"zfs_send_large_blocks" required:"false"`
+	Raw           bool   `mapstructure:"zfs_send_raw" required:"false"`
+}
+
+func (c *ZFSSendConfig) Prepare(ctx *interpolate.Context) (errs []error) {
+	if c.BaseSnapshot != "" && !strings.Contains(c.BaseSnapshot, "@") {
+		errs = append(errs, fmt.Errorf(
+			"zfs_send_base_snapshot must be a snapshot, for example zones/image@final"))
+	}
+
+	if c.Intermediates && c.BaseSnapshot == "" {
+		errs = append(errs, fmt.Errorf("zfs_send_intermediates requires zfs_send_base_snapshot"))
+	}
+
+	return
+}
+
+// streamType returns the kind of stream that is sent, which determines what
+// zfs receive needs on the other end: "full", "incremental" for zfs send -i,
+// or "intermediate" for zfs send -I, which also carries the snapshots
+// between the base snapshot and the final one.
+func (c ZFSSendConfig) streamType() string {
+	switch {
+	case c.BaseSnapshot == "":
+		return "full"
+	case c.Intermediates:
+		return "intermediate"
+	default:
+		return "incremental"
+	}
+}
+
+// flags returns the zfs send flags, other than the incremental source.
+func (c ZFSSendConfig) flags() []string {
+	args := []string{}
+
+	if c.Compressed {
+		args = append(args, "-c")
+	}
+	if c.Embedded {
+		args = append(args, "-e")
+	}
+	if c.LargeBlocks {
+		args = append(args, "-L")
+	}
+	if c.Raw {
+		args = append(args, "-w")
+	}
+
+	return args
+}
+
+// args returns the zfs send arguments, sending incrementally from the base
+// snapshot if incremental is set.  Only the boot disk can be sent
+// incrementally, as the base snapshot must be related to it.
+func (c ZFSSendConfig) args(incremental bool) []string {
+	args := c.flags()
+
+	if incremental && c.BaseSnapshot != "" {
+		if c.Intermediates {
+			args = append(args, "-I", c.BaseSnapshot)
+		} else {
+			args = append(args, "-i", c.BaseSnapshot)
+		}
+	}
+
+	return args
+}
@@ -0,0 +1,91 @@
+package bhyve
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+// ZvolConfig holds the properties that zvols are created with.
+type ZvolConfig struct {
+	Compression  string            `mapstructure:"disk_zvol_compression" required:"false"`
+	Properties   map[string]string `mapstructure:"disk_zvol_properties" required:"false"`
+	Sparse       bool              `mapstructure:"disk_zvol_sparse" required:"false"`
+	Volblocksize string            `mapstructure:"disk_zvol_volblocksize" required:"false"`
+}
+
+func (c *ZvolConfig) Prepare(ctx *interpolate.Context, config *Config) (errs []error) {
+	if !config.DiskUseZVOL && (c.Compression != "" || len(c.Properties) > 0 ||
+		c.Sparse || c.Volblocksize != "") {
+		errs = append(errs, fmt.Errorf("disk_zvol_* options require disk_use_zvol"))
+	}
+
+	if c.Volblocksize != "" {
+		size, err := parseDiskSize(c.Volblocksize)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid disk_zvol_volblocksize: %s", err))
+		} else if size < 512 || size > 1024*1024 || size&(size-1) != 0 {
+			errs = append(errs, fmt.Errorf(
+				"disk_zvol_volblocksize must be a power of two from 512 to 1M"))
+		}
+
+		// A clone has the block size of its origin.
+		if config.DiskSourceSnapshot != "" {
+			errs = append(errs, fmt.Errorf(
+				"disk_zvol_volblocksize cannot be used with disk_source_snapshot"))
+		}
+	}
+
+	for name := range c.Properties {
+		switch name {
+		case "volsize":
+			errs = append(errs, fmt.Errorf(
+				"disk_zvol_properties cannot set volsize, use disk_size"))
+		case "volblocksize":
+			if c.Volblocksize != "" {
+				errs = append(errs, fmt.Errorf(
+					"disk_zvol_properties and disk_zvol_volblocksize both set volblocksize"))
+			}
+			if config.DiskSourceSnapshot != "" {
+				errs = append(errs, fmt.Errorf(
+					"disk_zvol_properties cannot set volblocksize with disk_source_snapshot"))
+			}
+		case "compression":
+			if c.Compression != "" {
+				errs = append(errs, fmt.Errorf(
+					"disk_zvol_properties and disk_zvol_compression both set compression"))
+			}
+		}
+	}
+
+	return
+}
+
+// options returns the zfs create -o options, in a stable order.  A clone
+// keeps the block size of its origin, so it is left out for clones.
+func (c ZvolConfig) options(clone bool) []string {
+	properties := map[string]string{}
+	for name, value := range c.Properties {
+		properties[name] = value
+	}
+	if c.Compression != "" {
+		properties["compression"] = c.Compression
+	}
+	if c.Volblocksize != "" && !clone {
+		properties["volblocksize"] = c.Volblocksize
+	}
+
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := []string{}
+	for _, name := range names {
+		args = append(args, "-o", fmt.Sprintf("%s=%s", name, properties[name]))
+	}
+
+	return args
+}
@@ -0,0 +1,89 @@
+package bhyve
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestZvolConfigOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ZvolConfig
+		clone  bool
+		want   []string
+	}{
+		{"empty", ZvolConfig{}, false, []string{}},
+		{"sparse only", ZvolConfig{Sparse: true}, false, []string{}},
+		{
+			"all",
+			ZvolConfig{
+				Compression:  "lz4",
+				Volblocksize: "16K",
+				Properties:   map[string]string{"org.example:role": "image", "copies": "2"},
+			},
+			false,
+			[]string{"-o", "compression=lz4", "-o", "copies=2",
+				"-o", "org.example:role=image", "-o", "volblocksize=16K"},
+		},
+		{
+			"clone",
+			ZvolConfig{Compression: "lz4", Volblocksize: "16K"},
+			true,
+			[]string{"-o", "compression=lz4"},
+		},
+		{
+			"property volblocksize",
+			ZvolConfig{Properties: map[string]string{"volblocksize": "8K"}},
+			false,
+			[]string{"-o", "volblocksize=8K"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.config.options(tt.clone); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: options() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestZvolConfigPrepare(t *testing.T) {
+	tests := []struct {
+		name     string
+		zvol     ZvolConfig
+		use_zvol bool
+		source   string
+		errs     int
+	}{
+		{"unset", ZvolConfig{}, false, "", 0},
+		{"without zvol", ZvolConfig{Sparse: true}, false, "", 1},
+		{"volblocksize", ZvolConfig{Volblocksize: "16K"}, true, "", 0},
+		{"volblocksize too small", ZvolConfig{Volblocksize: "256"}, true, "", 1},
+		{"volblocksize too large", ZvolConfig{Volblocksize: "2M"}, true, "", 1},
+		{"volblocksize not power of two", ZvolConfig{Volblocksize: "12K"}, true, "", 1},
+		{"volblocksize invalid", ZvolConfig{Volblocksize: "big"}, true, "", 1},
+		{"volblocksize with clone", ZvolConfig{Volblocksize: "16K"}, true, "zones/base@final", 1},
+		{
+			"volblocksize property with clone",
+			ZvolConfig{Properties: map[string]string{"volblocksize": "8K"}},
+			true, "zones/base@final", 1,
+		},
+		{"volsize property", ZvolConfig{Properties: map[string]string{"volsize": "1G"}}, true, "", 1},
+		{
+			"volblocksize twice",
+			ZvolConfig{Volblocksize: "16K", Properties: map[string]string{"volblocksize": "8K"}},
+			true, "", 1,
+		},
+		{
+			"compression twice",
+			ZvolConfig{Compression: "lz4", Properties: map[string]string{"compression": "off"}},
+			true, "", 1,
+		},
+	}
+
+	for _, tt := range tests {
+		c := &Config{DiskUseZVOL: tt.use_zvol, DiskSourceSnapshot: tt.source}
+		if errs := tt.zvol.Prepare(nil, c); len(errs) != tt.errs {
+			t.Errorf("%s: errors = %v, want %d", tt.name, errs, tt.errs)
+		}
+	}
+}